@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/tozny/utils-go"
+)
+
+// rsaHashes maps supported RSA signing algorithms to the hash they sign
+var rsaHashes = map[string]crypto.Hash{
+	jwt.RS256: crypto.SHA256,
+	jwt.RS384: crypto.SHA384,
+	jwt.RS512: crypto.SHA512,
+}
+
+// SignJSON signs the canonical (RFC 8785) JSON form of v using the TokenFactory's
+// signing key and algorithm, returning the base64url encoded signature and error (if any).
+// To sign a raw JSON body pass it as a json.RawMessage.
+func (tf *TokenFactory) SignJSON(v interface{}) (string, error) {
+	hash, digest, err := canonicalJSONDigest(v, tf.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, tf.SigningKey, hash, digest)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyJSON verifies signature was produced by SignJSON for the canonical JSON form
+// of v using the TokenFactory's signing key, returning a non-nil error if it was not.
+func (tf *TokenFactory) VerifyJSON(v interface{}, signature string) error {
+	return VerifyJSON(v, signature, &tf.SigningKey.PublicKey, tf.Algorithm)
+}
+
+// VerifyJSON verifies signature was produced by SignJSON for the canonical JSON form
+// of v by the private key matching publicKey using algorithm,
+// returning a non-nil error if it was not.
+func VerifyJSON(v interface{}, signature string, publicKey *rsa.PublicKey, algorithm string) error {
+	hash, digest, err := canonicalJSONDigest(v, algorithm)
+	if err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature, %s not valid base64", err)
+	}
+	return rsa.VerifyPKCS1v15(publicKey, hash, digest, decoded)
+}
+
+// canonicalJSONDigest returns the hash used by algorithm and the digest
+// of the canonical JSON form of v.
+func canonicalJSONDigest(v interface{}, algorithm string) (crypto.Hash, []byte, error) {
+	hash, ok := rsaHashes[algorithm]
+	if !ok {
+		return 0, nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	canonical, err := utils.CanonicalJSON(v)
+	if err != nil {
+		return 0, nil, err
+	}
+	hasher := hash.New()
+	hasher.Write(canonical)
+	return hash, hasher.Sum(nil), nil
+}
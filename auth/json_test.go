@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+// newTestTokenFactory returns a TokenFactory with a freshly generated signing key.
+func newTestTokenFactory(t *testing.T, algorithm string) *TokenFactory {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TokenFactory{SigningKey: key, Algorithm: algorithm}
+}
+
+func TestSignJSONRoundTrip(t *testing.T) {
+	factory := newTestTokenFactory(t, jwt.RS256)
+	for _, algorithm := range []string{jwt.RS256, jwt.RS384, jwt.RS512} {
+		factory.Algorithm = algorithm
+		signature, err := factory.SignJSON(map[string]interface{}{"b": 1, "a": []string{"x"}})
+		if err != nil {
+			t.Fatalf("Unexpected error signing with %s: %s", algorithm, err)
+		}
+		// Equal JSON with different key order and whitespace verifies
+		reordered := json.RawMessage(`{ "a": ["x"], "b": 1.0 }`)
+		if err := factory.VerifyJSON(reordered, signature); err != nil {
+			t.Errorf("Expected %s signature to verify, got %s", algorithm, err)
+		}
+		if err := VerifyJSON(reordered, signature, &factory.SigningKey.PublicKey, algorithm); err != nil {
+			t.Errorf("Expected %s signature to verify with the public key, got %s", algorithm, err)
+		}
+	}
+}
+
+func TestVerifyJSONRejectsTampering(t *testing.T) {
+	factory := newTestTokenFactory(t, jwt.RS256)
+	payload := map[string]interface{}{"client_id": "client-1", "amount": 10}
+	signature, err := factory.SignJSON(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := factory.VerifyJSON(map[string]interface{}{"client_id": "client-1", "amount": 1000}, signature); err == nil {
+		t.Errorf("Expected a tampered payload not to verify")
+	}
+	tamperedSignature := []byte(signature)
+	tamperedSignature[len(tamperedSignature)/2] ^= 1
+	if err := factory.VerifyJSON(payload, string(tamperedSignature)); err == nil {
+		t.Errorf("Expected a tampered signature not to verify")
+	}
+	if err := factory.VerifyJSON(payload, "not base64!"); err == nil {
+		t.Errorf("Expected an invalid signature encoding not to verify")
+	}
+	if err := VerifyJSON(payload, signature, &factory.SigningKey.PublicKey, jwt.RS512); err == nil {
+		t.Errorf("Expected a signature not to verify with another algorithm")
+	}
+	if err := VerifyJSON(payload, signature, &factory.SigningKey.PublicKey, jwt.HS256); err == nil {
+		t.Errorf("Expected an unsupported algorithm to be rejected")
+	}
+}
+
+func TestVerifyJSONRejectsWrongKey(t *testing.T) {
+	factory := newTestTokenFactory(t, jwt.RS256)
+	other := newTestTokenFactory(t, jwt.RS256)
+	payload := map[string]string{"client_id": "client-1"}
+	signature, err := factory.SignJSON(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.VerifyJSON(payload, signature); err == nil {
+		t.Errorf("Expected a signature not to verify with another key")
+	}
+	if err := VerifyJSON(payload, signature, &other.SigningKey.PublicKey, jwt.RS256); err == nil {
+		t.Errorf("Expected a signature not to verify with another public key")
+	}
+}
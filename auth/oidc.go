@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/pascaldekloe/jwt"
+	"github.com/tozny/utils-go/clock"
 )
 
 // Republish supported algorithm constants
@@ -24,13 +25,27 @@ type Claims = jwt.Claims
 type TokenFactory struct {
 	SigningKey *rsa.PrivateKey
 	Algorithm  string
+	Clock      clock.Clock // Source of the issued and expiry times, nil uses the system clock
+}
+
+// TokenFactoryOption configures optional behavior of a TokenFactory.
+type TokenFactoryOption func(*TokenFactory)
+
+// WithClock sets the clock a TokenFactory uses to stamp issued and expiry times.
+func WithClock(c clock.Clock) TokenFactoryOption {
+	return func(tf *TokenFactory) {
+		tf.Clock = c
+	}
 }
 
 // NewTokenFactory sets up a new TokenFactory parsing the singing key and algorithm.
-func NewTokenFactory(signingKey string, algorithm string) (*TokenFactory, error) {
+func NewTokenFactory(signingKey string, algorithm string, options ...TokenFactoryOption) (*TokenFactory, error) {
 	tokenFactory := TokenFactory{
 		Algorithm: algorithm,
 	}
+	for _, option := range options {
+		option(&tokenFactory)
+	}
 	privateKey, err := parseRSAKey(signingKey)
 	if err != nil {
 		return &tokenFactory, fmt.Errorf("could not create token factory: %+v", err)
@@ -41,7 +56,7 @@ func NewTokenFactory(signingKey string, algorithm string) (*TokenFactory, error)
 
 // Sign creates a fully signed and encoded JWT from a set of token claims
 func (tf *TokenFactory) Sign(claims Claims, validTime time.Duration) ([]byte, error) {
-	now := time.Now()
+	now := clock.OrNew(tf.Clock).Now()
 	claims.Issued = jwt.NewNumericTime(now.Round(time.Second))
 	if validTime > 0 {
 		claims.Expires = jwt.NewNumericTime(now.Add(validTime).Round(time.Second))
@@ -0,0 +1,326 @@
+// Package breaker provides a circuit breaker for wrapping calls to outbound
+// dependencies (e.g. Elasticsearch, AWS SQS or an authentication service) so that
+// callers fail fast while a dependency is degraded instead of waiting on it.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+	"github.com/tozny/utils-go/logging"
+)
+
+const (
+	// DefaultFailureRateThreshold is the failure rate at which a breaker opens
+	DefaultFailureRateThreshold = 0.5
+	// DefaultMinimumRequests is the number of requests in the window before the failure rate is evaluated
+	DefaultMinimumRequests = 10
+	// DefaultWindow is the length of the rolling window failures are counted over
+	DefaultWindow = 60 * time.Second
+	// DefaultWindowBuckets is the number of buckets the rolling window is divided into
+	DefaultWindowBuckets = 10
+	// DefaultOpenTimeout is how long a breaker stays open before allowing trial requests
+	DefaultOpenTimeout = 30 * time.Second
+	// DefaultHalfOpenMaxRequests is the number of trial requests allowed while half-open
+	DefaultHalfOpenMaxRequests = 1
+)
+
+var (
+	// ErrOpen is returned without calling the dependency while a breaker is open
+	ErrOpen = errors.New("circuit breaker is open")
+	// ErrTooManyRequests is returned without calling the dependency while a breaker is
+	// half-open and already has the maximum number of trial requests in flight
+	ErrTooManyRequests = errors.New("circuit breaker is half-open and at its trial request limit")
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed breakers pass all requests to the dependency, counting failures
+	Closed State = iota
+	// Open breakers reject all requests until the open timeout has passed
+	Open
+	// HalfOpen breakers pass a limited number of trial requests, closing once
+	// HalfOpenMaxRequests trials in a row succeed and opening again if any fail
+	HalfOpen
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// Config wraps configuration for a circuit breaker. Zero values use the package defaults.
+type Config struct {
+	Name                 string           // Name of the protected dependency, used in logs and status reports
+	FailureRateThreshold float64          // Fraction (0 to 1) of failed requests in the window that opens the breaker
+	MinimumRequests      int              // Number of requests in the window before the failure rate is evaluated
+	Window               time.Duration    // Length of the rolling window failures are counted over
+	WindowBuckets        int              // Number of buckets the window is divided into
+	OpenTimeout          time.Duration    // How long to stay open before allowing trial requests
+	HalfOpenMaxRequests  int              // Number of concurrent trial requests allowed, and successes needed to close, while half-open
+	IsFailure            func(error) bool // Reports which errors count as failures, nil counts every error except context.Canceled
+	Logger               logging.Logger   // Logger for state changes, nil disables logging
+	Clock                clock.Clock      // Source of time for the window and open timeout, nil uses the system clock
+}
+
+// Status is a point in time report of a circuit breaker's state.
+type Status struct {
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	Requests    int     `json:"requests"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// bucket counts the requests made during one slice of the rolling window.
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Breaker is a circuit breaker with closed, open and half-open states, opening
+// when the failure rate over a rolling window crosses a threshold.
+type Breaker struct {
+	config           Config
+	mutex            sync.Mutex
+	state            State
+	openedAt         time.Time
+	buckets          []bucket
+	halfOpenInFlight int
+	halfOpenSuccess  int
+	// generation is incremented on every state change, so outcomes of requests
+	// admitted before the change are not counted against the new state
+	generation uint64
+}
+
+// New returns a new closed circuit breaker configured with the provided config.
+func New(config Config) *Breaker {
+	if config.FailureRateThreshold <= 0 {
+		config.FailureRateThreshold = DefaultFailureRateThreshold
+	}
+	if config.MinimumRequests <= 0 {
+		config.MinimumRequests = DefaultMinimumRequests
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	if config.WindowBuckets <= 0 {
+		config.WindowBuckets = DefaultWindowBuckets
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DefaultOpenTimeout
+	}
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = DefaultHalfOpenMaxRequests
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = func(err error) bool { return err != nil && !errors.Is(err, context.Canceled) }
+	}
+	config.Clock = clock.OrNew(config.Clock)
+	return &Breaker{
+		config:  config,
+		buckets: make([]bucket, config.WindowBuckets),
+	}
+}
+
+// Execute runs operation if the breaker allows it, recording the outcome,
+// and returns the error from operation. If the breaker does not allow the
+// request ErrOpen or ErrTooManyRequests is returned without running operation.
+// If operation panics the request is recorded as a failure before the panic continues.
+func (b *Breaker) Execute(operation func() error) error {
+	return b.ExecuteContext(context.Background(), operation)
+}
+
+// ExecuteContext is Execute for an operation made on behalf of ctx. If operation
+// fails because ctx was cancelled or its deadline passed the caller gave up rather
+// than the dependency failing, so the request is not counted as a success or failure.
+func (b *Breaker) ExecuteContext(ctx context.Context, operation func() error) error {
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			b.record(generation, nil, true)
+		}
+	}()
+	err = operation()
+	completed = true
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		b.release(generation)
+		return err
+	}
+	b.record(generation, err, b.config.IsFailure(err))
+	return err
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refreshState(b.config.Clock.Now())
+	return b.state
+}
+
+// Status returns a report of the breaker's state and the requests counted in its window.
+func (b *Breaker) Status() Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.config.Clock.Now()
+	b.refreshState(now)
+	requests, failures := b.counts(now)
+	status := Status{
+		Name:     b.config.Name,
+		State:    b.state.String(),
+		Requests: requests,
+		Failures: failures,
+	}
+	if requests > 0 {
+		status.FailureRate = float64(failures) / float64(requests)
+	}
+	return status
+}
+
+// Check returns ErrOpen if the breaker is open, for use as a health check.
+func (b *Breaker) Check(ctx context.Context) error {
+	if b.State() == Open {
+		return fmt.Errorf("%s: %w", b.config.Name, ErrOpen)
+	}
+	return nil
+}
+
+// allow reports whether a request may be made, reserving a trial slot if half-open,
+// and returns the generation the request was admitted in.
+func (b *Breaker) allow() (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refreshState(b.config.Clock.Now())
+	switch b.state {
+	case Open:
+		return 0, ErrOpen
+	case HalfOpen:
+		if b.halfOpenInFlight >= b.config.HalfOpenMaxRequests {
+			return 0, ErrTooManyRequests
+		}
+		b.halfOpenInFlight++
+	}
+	return b.generation, nil
+}
+
+// record counts the outcome of a request admitted in generation and transitions
+// state as needed. Outcomes of requests admitted before the last state change are ignored.
+func (b *Breaker) record(generation uint64, err error, failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if generation != b.generation {
+		return
+	}
+	now := b.config.Clock.Now()
+	switch b.state {
+	case HalfOpen:
+		if b.halfOpenInFlight > 0 {
+			b.halfOpenInFlight--
+		}
+		if failed {
+			b.transition(Open, now, err)
+			return
+		}
+		b.halfOpenSuccess++
+		if b.halfOpenSuccess >= b.config.HalfOpenMaxRequests {
+			b.transition(Closed, now, nil)
+		}
+	case Closed:
+		current := b.bucket(now)
+		current.requests++
+		if failed {
+			current.failures++
+		}
+		requests, failures := b.counts(now)
+		if requests >= b.config.MinimumRequests && float64(failures)/float64(requests) >= b.config.FailureRateThreshold {
+			b.transition(Open, now, err)
+		}
+	}
+}
+
+// release frees the trial slot held by a request admitted in generation without
+// counting its outcome.
+func (b *Breaker) release(generation uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if generation == b.generation && b.state == HalfOpen && b.halfOpenInFlight > 0 {
+		b.halfOpenInFlight--
+	}
+}
+
+// refreshState moves an open breaker to half-open once its open timeout has passed.
+func (b *Breaker) refreshState(now time.Time) {
+	if b.state == Open && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.transition(HalfOpen, now, nil)
+	}
+}
+
+// transition changes the breaker's state, resetting counters and logging the change.
+func (b *Breaker) transition(state State, now time.Time, cause error) {
+	previous := b.state
+	b.state = state
+	b.generation++
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccess = 0
+	if state == Open {
+		b.openedAt = now
+	}
+	b.buckets = make([]bucket, b.config.WindowBuckets)
+	if b.config.Logger == nil {
+		return
+	}
+	if state == Open {
+		b.config.Logger.Errorf("CircuitBreaker %s: %s -> %s after error %v", b.config.Name, previous, state, cause)
+		return
+	}
+	b.config.Logger.Infof("CircuitBreaker %s: %s -> %s", b.config.Name, previous, state)
+}
+
+// bucketWidth is the length of time covered by each bucket.
+func (b *Breaker) bucketWidth() time.Duration {
+	return b.config.Window / time.Duration(b.config.WindowBuckets)
+}
+
+// bucket returns the bucket for now, resetting it if it holds counts from a previous window.
+func (b *Breaker) bucket(now time.Time) *bucket {
+	width := b.bucketWidth()
+	start := now.Truncate(width)
+	current := &b.buckets[int(start.UnixNano()/int64(width))%len(b.buckets)]
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+	return current
+}
+
+// counts totals the requests and failures recorded within the window ending at now.
+func (b *Breaker) counts(now time.Time) (int, int) {
+	var requests, failures int
+	oldest := now.Add(-b.config.Window)
+	for _, bucket := range b.buckets {
+		if bucket.start.After(oldest) {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
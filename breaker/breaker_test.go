@@ -0,0 +1,149 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+var errDependency = errors.New("dependency unavailable")
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	circuitBreaker := New(Config{
+		Name:            "test",
+		MinimumRequests: 4,
+		OpenTimeout:     30 * time.Second,
+		Clock:           fakeClock,
+	})
+	for _, err := range []error{nil, errDependency, nil, errDependency} {
+		circuitBreaker.Execute(func() error { return err })
+	}
+	if state := circuitBreaker.State(); state != Open {
+		t.Fatalf("Expected breaker to open at a 50%% failure rate, got %s", state)
+	}
+	called := false
+	if err := circuitBreaker.Execute(func() error { called = true; return nil }); err != ErrOpen || called {
+		t.Errorf("Expected open breaker to reject the call with ErrOpen, got %v (called %t)", err, called)
+	}
+	fakeClock.Advance(29 * time.Second)
+	if state := circuitBreaker.State(); state != Open {
+		t.Fatalf("Expected breaker to stay open before the open timeout, got %s", state)
+	}
+	fakeClock.Advance(time.Second)
+	if state := circuitBreaker.State(); state != HalfOpen {
+		t.Fatalf("Expected breaker to be half-open after the open timeout, got %s", state)
+	}
+	if err := circuitBreaker.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Expected trial request to be allowed, got %v", err)
+	}
+	if state := circuitBreaker.State(); state != Closed {
+		t.Errorf("Expected successful trial request to close the breaker, got %s", state)
+	}
+}
+
+func TestBreakerIgnoresNonFailures(t *testing.T) {
+	errNotFound := errors.New("not found")
+	circuitBreaker := New(Config{
+		MinimumRequests: 1,
+		IsFailure:       func(err error) bool { return err != nil && err != errNotFound },
+	})
+	for i := 0; i < 5; i++ {
+		circuitBreaker.Execute(func() error { return errNotFound })
+	}
+	status := circuitBreaker.Status()
+	if status.State != "closed" || status.Requests != 5 || status.Failures != 0 {
+		t.Errorf("Expected closed breaker with 5 successful requests, got %+v", status)
+	}
+}
+
+func TestBreakerIgnoresOutcomesFromPreviousStates(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	circuitBreaker := New(Config{MinimumRequests: 1, OpenTimeout: time.Second, Clock: fakeClock})
+	started, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	// Admitted while closed, finishing only once the breaker is half-open
+	go func() {
+		done <- circuitBreaker.Execute(func() error { close(started); <-release; return nil })
+	}()
+	<-started
+	circuitBreaker.Execute(func() error { return errDependency })
+	fakeClock.Advance(time.Second)
+	if state := circuitBreaker.State(); state != HalfOpen {
+		t.Fatalf("Expected breaker to be half-open, got %s", state)
+	}
+	close(release)
+	<-done
+	if state := circuitBreaker.State(); state != HalfOpen {
+		t.Errorf("Expected a stale success not to close the breaker, got %s", state)
+	}
+}
+
+func TestBreakerReleasesTrialSlotOnPanic(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	circuitBreaker := New(Config{MinimumRequests: 1, OpenTimeout: time.Second, Clock: fakeClock})
+	circuitBreaker.Execute(func() error { return errDependency })
+	fakeClock.Advance(time.Second)
+	func() {
+		defer func() { recover() }()
+		circuitBreaker.Execute(func() error { panic("trial request panicked") })
+	}()
+	if state := circuitBreaker.State(); state != Open {
+		t.Fatalf("Expected a panicking trial request to reopen the breaker, got %s", state)
+	}
+	fakeClock.Advance(time.Second)
+	if err := circuitBreaker.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Expected a trial slot to be available after the panic, got %v", err)
+	}
+}
+
+func TestBreakerRequiresConsecutiveTrialSuccesses(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	circuitBreaker := New(Config{MinimumRequests: 1, OpenTimeout: time.Second, HalfOpenMaxRequests: 3, Clock: fakeClock})
+	circuitBreaker.Execute(func() error { return errDependency })
+	fakeClock.Advance(time.Second)
+	for i := 0; i < 2; i++ {
+		if err := circuitBreaker.Execute(func() error { return nil }); err != nil {
+			t.Fatalf("Expected trial request to be allowed, got %v", err)
+		}
+		if state := circuitBreaker.State(); state != HalfOpen {
+			t.Fatalf("Expected breaker to stay half-open after %d successful trials, got %s", i+1, state)
+		}
+	}
+	circuitBreaker.Execute(func() error { return nil })
+	if state := circuitBreaker.State(); state != Closed {
+		t.Errorf("Expected breaker to close after 3 successful trials, got %s", state)
+	}
+}
+
+func TestBreakerIgnoresCallerCancellation(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	circuitBreaker := New(Config{MinimumRequests: 1, OpenTimeout: time.Second, Clock: fakeClock})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancelTimeout := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelTimeout()
+	circuitBreaker.Execute(func() error { return context.Canceled })
+	circuitBreaker.ExecuteContext(ctx, func() error { return ctx.Err() })
+	circuitBreaker.ExecuteContext(timedOut, func() error { return timedOut.Err() })
+	if state := circuitBreaker.State(); state != Closed {
+		t.Fatalf("Expected cancelled callers not to open the breaker, got %s", state)
+	}
+	circuitBreaker.ExecuteContext(context.Background(), func() error { return context.DeadlineExceeded })
+	if state := circuitBreaker.State(); state != Open {
+		t.Fatalf("Expected a dependency timeout to open the breaker, got %s", state)
+	}
+	fakeClock.Advance(time.Second)
+	circuitBreaker.ExecuteContext(ctx, func() error { return ctx.Err() })
+	if state := circuitBreaker.State(); state != HalfOpen {
+		t.Fatalf("Expected a cancelled trial to leave the breaker half-open, got %s", state)
+	}
+	if err := circuitBreaker.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Expected the cancelled trial's slot to be released, got %v", err)
+	}
+	if state := circuitBreaker.State(); state != Closed {
+		t.Errorf("Expected a successful trial to close the breaker, got %s", state)
+	}
+}
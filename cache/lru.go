@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+// LRU is a bounded in-process cache safe for concurrent use. Each entry expires after
+// its own time to live, and once full the least recently used entry is evicted to make
+// room for new entries.
+type LRU[K comparable, V any] struct {
+	capacity int
+	clock    clock.Clock
+	mutex    sync.Mutex
+	entries  map[K]*list.Element
+	order    *list.List // Front is the most recently used entry
+}
+
+// lruEntry is a value stored in an LRU with the key it is stored under and its expiry.
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// NewLRU returns a new empty LRU holding at most capacity entries (at least one),
+// which measures expiry with c, or the system clock if c is nil.
+func NewLRU[K comparable, V any](capacity int, c clock.Clock) *LRU[K, V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRU[K, V]{
+		capacity: capacity,
+		clock:    clock.OrNew(c),
+		entries:  map[K]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// Get returns the unexpired value stored for key and whether there was one,
+// marking it as the most recently used entry.
+func (l *LRU[K, V]) Get(key K) (V, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var zero V
+	element, ok := l.entries[key]
+	if !ok {
+		return zero, false
+	}
+	entry := element.Value.(*lruEntry[K, V])
+	if !l.clock.Now().Before(entry.expires) {
+		l.remove(element)
+		return zero, false
+	}
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set stores value for key for ttl, replacing any existing value and evicting the
+// least recently used entry if the cache is full. Values with a ttl of zero or less
+// are not stored.
+func (l *LRU[K, V]) Set(key K, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		l.Delete(key)
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	expires := l.clock.Now().Add(ttl)
+	if element, ok := l.entries[key]; ok {
+		entry := element.Value.(*lruEntry[K, V])
+		entry.value, entry.expires = value, expires
+		l.order.MoveToFront(element)
+		return
+	}
+	for l.order.Len() >= l.capacity {
+		l.remove(l.order.Back())
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry[K, V]{key: key, value: value, expires: expires})
+}
+
+// Delete removes any value stored for key.
+func (l *LRU[K, V]) Delete(key K) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+}
+
+// Len returns the number of entries stored, including any which have expired but
+// not yet been removed.
+func (l *LRU[K, V]) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.order.Len()
+}
+
+// remove deletes element from the cache, the caller must hold the mutex.
+func (l *LRU[K, V]) remove(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.entries, element.Value.(*lruEntry[K, V]).key)
+}
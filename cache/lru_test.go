@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	lru := NewLRU[string, int](2, nil)
+	lru.Set("a", 1, time.Minute)
+	lru.Set("b", 2, time.Minute)
+	if _, ok := lru.Get("a"); !ok {
+		t.Fatalf("Expected a to be cached")
+	}
+	lru.Set("c", 3, time.Minute)
+	if _, ok := lru.Get("b"); ok {
+		t.Errorf("Expected b to be evicted as least recently used")
+	}
+	if value, ok := lru.Get("a"); !ok || value != 1 {
+		t.Errorf("Expected a to remain cached with 1, got %d %t", value, ok)
+	}
+	if lru.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", lru.Len())
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	lru := NewLRU[string, int](10, fake)
+	lru.Set("short", 1, time.Second)
+	lru.Set("long", 2, time.Minute)
+	lru.Set("never", 3, 0)
+	fake.Advance(time.Second)
+	if _, ok := lru.Get("short"); ok {
+		t.Errorf("Expected short to have expired")
+	}
+	if _, ok := lru.Get("long"); !ok {
+		t.Errorf("Expected long to still be cached")
+	}
+	if _, ok := lru.Get("never"); ok {
+		t.Errorf("Expected entry with no ttl to not be stored")
+	}
+}
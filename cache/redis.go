@@ -2,6 +2,7 @@
 package cache
 
 import (
+	"context"
 	"crypto/tls"
 
 	"github.com/redis/go-redis/v9"
@@ -9,10 +10,10 @@ import (
 
 // Config wraps configuration for a redis client.
 type Config struct {
-	Address            string
-	Password           string
-	ClusterModeEnabled bool
-	TLSEnabled         bool
+	Address            string `env:"REDIS_ADDRESS,required"`
+	Password           string `env:"REDIS_PASSWORD"`
+	ClusterModeEnabled bool   `env:"REDIS_CLUSTER_MODE_ENABLED" default:"false"`
+	TLSEnabled         bool   `env:"REDIS_TLS_ENABLED" default:"false"`
 }
 
 // NewClient returns a new redis client configured with the provided config.
@@ -39,3 +40,11 @@ func NewClient(config Config) redis.Cmdable {
 	}
 	return client
 }
+
+// HealthCheck returns a check which sends a PING to the redis server(s) behind client,
+// for registering with a health.Registry.
+func HealthCheck(client redis.Cmdable) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
@@ -0,0 +1,335 @@
+package utils
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidCanonicalJSON is returned when JSON can not be represented in canonical form
+	ErrInvalidCanonicalJSON = errors.New("invalid JSON for canonicalization")
+)
+
+// CanonicalJSON marshals v to JSON and returns it in the canonical form defined
+// by the JSON Canonicalization Scheme (RFC 8785): no insignificant whitespace,
+// object members sorted by key, and strings and numbers serialized in a single
+// well defined way. Equal values always produce byte for byte equal output,
+// making it suitable for hashing and signing structured payloads. Strings holding
+// invalid UTF-8 return an error wrapping ErrInvalidCanonicalJSON, as json.Marshal
+// would otherwise replace them with U+FFFD. A json.RawMessage is canonicalized as is.
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	if raw, ok := v.(json.RawMessage); ok {
+		return CanonicalizeJSON(raw)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateUTF8Strings(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return CanonicalizeJSON(raw)
+}
+
+// validateUTF8Strings returns an error if any string json.Marshal encodes from value,
+// including map keys and the text of encoding.TextMarshalers, is not valid UTF-8.
+// The output of json.Marshalers is left to CanonicalizeJSON to validate.
+func validateUTF8Strings(value reflect.Value) error {
+	if !value.IsValid() {
+		return nil
+	}
+	if value.CanInterface() {
+		switch marshaler := value.Interface().(type) {
+		case json.Marshaler:
+			return nil
+		case encoding.TextMarshaler:
+			if value.Kind() == reflect.Ptr && value.IsNil() {
+				return nil
+			}
+			text, err := marshaler.MarshalText()
+			if err == nil && !utf8.Valid(text) {
+				return fmt.Errorf("%w: string is not valid UTF-8", ErrInvalidCanonicalJSON)
+			}
+			return nil
+		}
+	}
+	switch value.Kind() {
+	case reflect.String:
+		if !utf8.ValidString(value.String()) {
+			return fmt.Errorf("%w: string is not valid UTF-8", ErrInvalidCanonicalJSON)
+		}
+	case reflect.Ptr, reflect.Interface:
+		return validateUTF8Strings(value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Type().Field(i); field.IsExported() || field.Anonymous {
+				if err := validateUTF8Strings(value.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		iterator := value.MapRange()
+		for iterator.Next() {
+			if err := validateUTF8Strings(iterator.Key()); err != nil {
+				return err
+			}
+			if err := validateUTF8Strings(iterator.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64
+			return nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := validateUTF8Strings(value.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CanonicalizeJSON converts the JSON document raw (e.g. a request body extracted
+// by server.ExtractBodyMiddleware) to its RFC 8785 canonical form, returning an
+// error if raw is not a single valid JSON value, contains duplicate object keys, or
+// contains invalid UTF-8 or unpaired surrogate escapes, which RFC 8785 forbids.
+func CanonicalizeJSON(raw []byte) ([]byte, error) {
+	if err := validateCanonicalStrings(raw); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var canonical bytes.Buffer
+	if err := writeCanonicalValue(&canonical, decoder); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: unexpected data after top-level value", ErrInvalidCanonicalJSON)
+	}
+	return canonical.Bytes(), nil
+}
+
+// HashJSON uses blake2b to hash the canonical JSON form of v and returns the
+// result in a base64 url encoded format. Returning an error if any.
+func HashJSON(v interface{}) (string, error) {
+	canonical, err := CanonicalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return HashAndEncodeReader(bytes.NewReader(canonical), DefaultHashSize, nil)
+}
+
+// validateCanonicalStrings returns an error if raw is not valid UTF-8 or any string in
+// it escapes an unpaired UTF-16 surrogate, which encoding/json would silently decode as
+// U+FFFD so that distinct documents would share a canonical form.
+func validateCanonicalStrings(raw []byte) error {
+	if !utf8.Valid(raw) {
+		return fmt.Errorf("%w: invalid UTF-8", ErrInvalidCanonicalJSON)
+	}
+	inString := false
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case raw[i] == '"':
+			inString = !inString
+		case inString && raw[i] == '\\':
+			unit, ok := escapedUTF16(raw[i:])
+			if !ok {
+				// Skip the escaped character, the decoder reports invalid escapes
+				i++
+				continue
+			}
+			i += 5
+			if !utf16.IsSurrogate(rune(unit)) {
+				continue
+			}
+			next, ok := escapedUTF16(raw[i+1:])
+			if unit >= 0xdc00 || !ok || next < 0xdc00 || next > 0xdfff {
+				return fmt.Errorf("%w: unpaired surrogate \\u%04x", ErrInvalidCanonicalJSON, unit)
+			}
+			i += 6
+		}
+	}
+	return nil
+}
+
+// escapedUTF16 returns the UTF-16 code unit escaped by the \uXXXX sequence raw starts with, if it does.
+func escapedUTF16(raw []byte) (uint16, bool) {
+	if len(raw) < 6 || raw[0] != '\\' || raw[1] != 'u' {
+		return 0, false
+	}
+	unit, err := strconv.ParseUint(string(raw[2:6]), 16, 16)
+	return uint16(unit), err == nil
+}
+
+// writeCanonicalValue reads the next JSON value from decoder and writes its
+// canonical form to out.
+func writeCanonicalValue(out *bytes.Buffer, decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCanonicalJSON, err)
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '[' {
+			return writeCanonicalArray(out, decoder)
+		}
+		return writeCanonicalObject(out, decoder)
+	case string:
+		writeCanonicalString(out, value)
+	case json.Number:
+		number, err := canonicalNumber(value)
+		if err != nil {
+			return err
+		}
+		out.WriteString(number)
+	case bool:
+		out.WriteString(strconv.FormatBool(value))
+	case nil:
+		out.WriteString("null")
+	}
+	return nil
+}
+
+// writeCanonicalArray writes the remaining elements of an array whose opening
+// delimiter has been read from decoder.
+func writeCanonicalArray(out *bytes.Buffer, decoder *json.Decoder) error {
+	out.WriteByte('[')
+	for index := 0; decoder.More(); index++ {
+		if index > 0 {
+			out.WriteByte(',')
+		}
+		if err := writeCanonicalValue(out, decoder); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCanonicalJSON, err)
+	}
+	out.WriteByte(']')
+	return nil
+}
+
+// writeCanonicalObject writes the remaining members of an object whose opening
+// delimiter has been read from decoder, sorted by the UTF-16 code units of their keys.
+func writeCanonicalObject(out *bytes.Buffer, decoder *json.Decoder) error {
+	members := map[string][]byte{}
+	keys := []string{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidCanonicalJSON, err)
+		}
+		key := token.(string)
+		if _, duplicate := members[key]; duplicate {
+			return fmt.Errorf("%w: duplicate object key %q", ErrInvalidCanonicalJSON, key)
+		}
+		var member bytes.Buffer
+		if err := writeCanonicalValue(&member, decoder); err != nil {
+			return err
+		}
+		members[key] = member.Bytes()
+		keys = append(keys, key)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCanonicalJSON, err)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessUTF16(keys[i], keys[j])
+	})
+	out.WriteByte('{')
+	for index, key := range keys {
+		if index > 0 {
+			out.WriteByte(',')
+		}
+		writeCanonicalString(out, key)
+		out.WriteByte(':')
+		out.Write(members[key])
+	}
+	out.WriteByte('}')
+	return nil
+}
+
+// lessUTF16 reports whether a sorts before b when compared as UTF-16 code units.
+func lessUTF16(a string, b string) bool {
+	unitsA := utf16.Encode([]rune(a))
+	unitsB := utf16.Encode([]rune(b))
+	for i := 0; i < len(unitsA) && i < len(unitsB); i++ {
+		if unitsA[i] != unitsB[i] {
+			return unitsA[i] < unitsB[i]
+		}
+	}
+	return len(unitsA) < len(unitsB)
+}
+
+// writeCanonicalString writes s as a JSON string, escaping only the characters
+// RFC 8785 requires to be escaped.
+func writeCanonicalString(out *bytes.Buffer, s string) {
+	out.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(out, `\u%04x`, r)
+				continue
+			}
+			out.WriteRune(r)
+		}
+	}
+	out.WriteByte('"')
+}
+
+// canonicalNumber formats a JSON number as an IEEE 754 double using the
+// ECMAScript Number serialization RFC 8785 requires.
+func canonicalNumber(number json.Number) (string, error) {
+	value, err := strconv.ParseFloat(string(number), 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return "", fmt.Errorf("%w: number %s can not be represented as a double", ErrInvalidCanonicalJSON, number)
+	}
+	if value == 0 {
+		// Also normalizes negative zero
+		return "0", nil
+	}
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	format := byte('e')
+	if value >= 1e-6 && value < 1e21 {
+		format = 'f'
+	}
+	formatted := strconv.FormatFloat(value, format, -1, 64)
+	if exponent := strings.IndexByte(formatted, 'e'); exponent > 0 && formatted[exponent+2] == '0' {
+		// ECMAScript writes 1e+9 where Go writes 1e+09
+		formatted = formatted[:exponent+2] + formatted[exponent+3:]
+	}
+	return sign + formatted, nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanonicalizeJSON(t *testing.T) {
+	cases := map[string]string{
+		// RFC 8785 section 3.2.2 serialization and 3.2.3 sorting examples
+		`{"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+		  "literals": [null, true, false]}`: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		`{"\u20ac": "Euro Sign", "\r": "Carriage Return", "\ufb33": "Hebrew Letter Dalet With Dagesh",
+		  "1": "One", "\ud83d\ude00": "Emoji: Grinning Face", "\u0080": "Control", "\u00f6": "Latin Small Letter O With Diaeresis"}`: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		`[-0, 1e21, 1e20, 0.000001, 1e-7, "<&>"]`: `[0,1e+21,100000000000000000000,0.000001,1e-7,"<&>"]`,
+	}
+	for input, expected := range cases {
+		canonical, err := CanonicalizeJSON([]byte(input))
+		if err != nil {
+			t.Errorf("Unexpected error %s canonicalizing %s", err, input)
+			continue
+		}
+		if string(canonical) != expected {
+			t.Errorf("Expected %s, got %s", expected, canonical)
+		}
+	}
+	for _, invalid := range []string{
+		`{"a":1,"a":2}`,
+		`{"a":1} {}`,
+		`{"a":}`,
+		``,
+		`"\ud800"`,
+		`"\udc00"`,
+		`"\ud83d\u0041"`,
+		`{"\ude00":1}`,
+		"\"\xff\"",
+		"\"caf\xc3\"",
+	} {
+		if _, err := CanonicalizeJSON([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error canonicalizing %q", invalid)
+		}
+	}
+}
+
+func TestCanonicalJSONRejectsInvalidUTF8(t *testing.T) {
+	if _, err := CanonicalJSON(map[string]string{"name": "caf\xc3"}); !errors.Is(err, ErrInvalidCanonicalJSON) {
+		t.Errorf("Expected ErrInvalidCanonicalJSON for an invalid UTF-8 value, got %v", err)
+	}
+	if _, err := CanonicalJSON(map[string]string{"caf\xc3": "name"}); !errors.Is(err, ErrInvalidCanonicalJSON) {
+		t.Errorf("Expected ErrInvalidCanonicalJSON for an invalid UTF-8 key, got %v", err)
+	}
+	canonical, err := CanonicalJSON(map[string]string{"name": "\ufffd"})
+	if err != nil || string(canonical) != "{\"name\":\"\ufffd\"}" {
+		t.Errorf("Expected a valid U+FFFD to be kept, got %s (error %v)", canonical, err)
+	}
+}
+
+func TestHashJSONIgnoresKeyOrder(t *testing.T) {
+	first, err := HashJSON(map[string]interface{}{"b": 1.0, "a": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := HashJSON(struct {
+		A []int `json:"a"`
+		B int   `json:"b"`
+	}{[]int{1, 2}, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("Expected equal hashes for equal JSON, got %s and %s", first, second)
+	}
+}
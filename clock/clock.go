@@ -0,0 +1,191 @@
+// Package clock provides an injectable source of time so that code which
+// depends on the current time, sleeps or timers can be tested deterministically
+// by substituting a Fake clock for the system clock.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the interface which wraps methods for reading the current time and
+// waiting for time to pass.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the interface for a single event timer created by a Clock.
+type Timer interface {
+	// C returns the channel the current time is delivered on when the timer fires
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, returning false if it already fired or was stopped
+	Stop() bool
+}
+
+// New returns a Clock backed by the system clock.
+func New() Clock {
+	return realClock{}
+}
+
+// OrNew returns c, or a Clock backed by the system clock if c is nil.
+func OrNew(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+	return c
+}
+
+// realClock implements Clock using the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+// realTimer implements Timer using a time.Timer.
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.timer.C }
+func (t realTimer) Stop() bool          { return t.timer.Stop() }
+
+// Fake is a Clock whose time only moves when Advance or Set is called, firing
+// any timers, sleeps and After channels that become due.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	waiters []chan struct{}
+}
+
+// NewFake returns a Fake clock set to start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the fake clock's current time.
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// Since returns the time elapsed on the fake clock since t.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Sleep blocks until the fake clock has been advanced by at least d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// After returns a channel the fake clock's time is sent on once it has been advanced by at least d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// NewTimer returns a Timer which fires once the fake clock has been advanced by at least d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	timer := &fakeTimer{
+		clock:    f,
+		deadline: f.now.Add(d),
+		channel:  make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		timer.channel <- f.now
+		return timer
+	}
+	f.timers = append(f.timers, timer)
+	f.notifyWaiters()
+	return timer
+}
+
+// Advance moves the fake clock forward by d, firing every timer that becomes due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the fake clock to t, firing every timer that becomes due in deadline order.
+func (f *Fake) Set(t time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = t
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].deadline.Before(f.timers[j].deadline)
+	})
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.deadline.After(t) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.channel <- t
+	}
+	f.timers = pending
+}
+
+// Timers returns the number of timers, sleeps and After channels waiting on the fake clock.
+func (f *Fake) Timers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil blocks until at least n timers, sleeps or After channels are waiting
+// on the fake clock, allowing tests to advance time only once the code under test
+// has started waiting.
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mutex.Lock()
+		if len(f.timers) >= n {
+			f.mutex.Unlock()
+			return
+		}
+		waiter := make(chan struct{})
+		f.waiters = append(f.waiters, waiter)
+		f.mutex.Unlock()
+		<-waiter
+	}
+}
+
+// notifyWaiters wakes every goroutine blocked in BlockUntil. Callers must hold the mutex.
+func (f *Fake) notifyWaiters() {
+	for _, waiter := range f.waiters {
+		close(waiter)
+	}
+	f.waiters = nil
+}
+
+// fakeTimer implements Timer for a Fake clock.
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	channel  chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.channel
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for index, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:index], t.clock.timers[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeFiresTimersInOrderWhenAdvanced(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFake(start)
+	later := fake.After(2 * time.Second)
+	sooner := fake.NewTimer(time.Second)
+	stopped := fake.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Errorf("Expected pending timer to stop")
+	}
+	fake.Advance(time.Second)
+	select {
+	case fired := <-sooner.C():
+		if !fired.Equal(start.Add(time.Second)) {
+			t.Errorf("Expected timer to fire at %s, got %s", start.Add(time.Second), fired)
+		}
+	default:
+		t.Errorf("Expected due timer to fire")
+	}
+	select {
+	case <-later:
+		t.Errorf("Timer fired before it was due")
+	case <-stopped.C():
+		t.Errorf("Stopped timer fired")
+	default:
+	}
+	if fake.Timers() != 1 {
+		t.Errorf("Expected one pending timer, got %d", fake.Timers())
+	}
+	fake.Advance(time.Second)
+	<-later
+	if since := fake.Since(start); since != 2*time.Second {
+		t.Errorf("Expected 2s since start, got %s", since)
+	}
+}
+
+func TestFakeSleepBlocksUntilAdvanced(t *testing.T) {
+	fake := NewFake(time.Now())
+	done := make(chan struct{})
+	go func() {
+		fake.Sleep(time.Hour)
+		close(done)
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(time.Hour)
+	<-done
+}
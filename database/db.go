@@ -7,7 +7,9 @@ import (
 
 	"github.com/go-pg/pg/v10"
 	migrations "github.com/robinjoseph08/go-pg-migrations/v3"
+	"github.com/tozny/utils-go/clock"
 	"github.com/tozny/utils-go/logging"
+	"github.com/tozny/utils-go/retry"
 )
 
 var (
@@ -17,14 +19,15 @@ var (
 
 // DBConfig wraps config for connecting to a database.
 type DBConfig struct {
-	Address       string
-	User          string
-	Database      string
-	Password      string
+	Address       string `env:"DB_ADDRESS,required"`
+	User          string `env:"DB_USER,required"`
+	Database      string `env:"DB_NAME,required"`
+	Password      string `env:"DB_PASSWORD,required"`
 	Logger        logging.Logger
-	EnableLogging bool
-	EnableTLS     bool
-	SkipVerifyTLS bool
+	EnableLogging bool        `env:"DB_ENABLE_LOGGING" default:"false"`
+	EnableTLS     bool        `env:"DB_ENABLE_TLS" default:"false"`
+	SkipVerifyTLS bool        `env:"DB_SKIP_VERIFY_TLS" default:"false"`
+	Clock         clock.Clock // Source of time for query timing, nil uses the system clock
 }
 
 // DB wraps a client for a database.
@@ -49,6 +52,7 @@ func (db *DB) Migrate() error {
 // dbLogger implements the DBLogger interface for the go-pg module
 type dbLogger struct {
 	logger logging.Logger
+	clock  clock.Clock
 }
 
 // context key for query timing context
@@ -56,7 +60,7 @@ var dlTimingKey struct{} = struct{}{}
 
 // BeforeQuery is called before a query is executed.
 func (d dbLogger) BeforeQuery(ctx context.Context, q *pg.QueryEvent) (context.Context, error) {
-	return context.WithValue(ctx, dlTimingKey, time.Now()), nil
+	return context.WithValue(ctx, dlTimingKey, d.clock.Now()), nil
 }
 
 // AfterQuery is called after a query is executed.
@@ -71,7 +75,7 @@ func (d dbLogger) AfterQuery(ctx context.Context, q *pg.QueryEvent) error {
 		d.logger.Errorf("Unable find timing context in query:\n%+v ", query)
 		return nil
 	}
-	d.logger.Infof("executed query in %s:\n%+v", time.Since(start), string(query))
+	d.logger.Infof("executed query in %s:\n%+v", d.clock.Since(start), string(query))
 	return nil
 }
 
@@ -89,7 +93,7 @@ func New(config DBConfig) DB {
 
 	db := pg.Connect(options)
 	if config.EnableLogging {
-		db.AddQueryHook(dbLogger{logger: config.Logger})
+		db.AddQueryHook(dbLogger{logger: config.Logger, clock: clock.OrNew(config.Clock)})
 	}
 	return DB{
 		Client:      db,
@@ -115,6 +119,12 @@ func (db *DB) Ping() error {
 	return err
 }
 
+// PingContext makes a call to the database, returning an error if it is unreachable
+// or ctx is done first. It implements health.Pinger for service checks.
+func (db *DB) PingContext(ctx context.Context) error {
+	return db.Client.Ping(ctx)
+}
+
 // RunMigrations is an initialization function for a DB which attempts to run migrations
 // once a second in a loop until they run successfully.
 func RunMigrations(db *DB) {
@@ -130,3 +140,20 @@ func RunMigrations(db *DB) {
 		break
 	}
 }
+
+// RetryMigrations returns an initialization function for a DB which attempts to run
+// migrations, retrying failures according to the provided retrier.
+func RetryMigrations(retrier retry.Retrier) func(*DB) {
+	return func(db *DB) {
+		db.Logger.Debug("DB.RetryMigrations: Running migrations.")
+		retrier.OnRetry = append([]retry.Hook{retry.LogHook(db.Logger, "DB.RetryMigrations")}, retrier.OnRetry...)
+		err := retrier.Do(context.Background(), func(context.Context) error {
+			return db.Migrate()
+		})
+		if err != nil {
+			db.Logger.Errorf("DB.RetryMigrations: error %v running migrations, giving up.", err)
+			return
+		}
+		db.Logger.Debug("DB.RetryMigrations: Migrations finished.")
+	}
+}
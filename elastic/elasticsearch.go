@@ -8,6 +8,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/olivere/elastic"
 	aws "github.com/olivere/elastic/aws/v4"
+	"github.com/tozny/utils-go/breaker"
 	"github.com/tozny/utils-go/logging"
 )
 
@@ -15,6 +16,7 @@ import (
 type ElasticClient struct {
 	*elastic.Client
 	logging.Logger
+	breaker *breaker.Breaker
 }
 
 // ElasticConfig wraps configuration to create either local or AWS Elasticsearch Client.
@@ -27,6 +29,24 @@ type ElasticConfig struct {
 	AccessKey   string
 	SecretKey   string
 	ServiceName string
+	Breaker     *breaker.Breaker // Optional circuit breaker guarding calls made through the client's methods
+}
+
+// Guard runs operation through the client's circuit breaker if one was configured,
+// failing fast with breaker.ErrOpen while Elasticsearch is degraded. Use it to guard
+// calls made directly on the embedded elastic.Client.
+func (ec *ElasticClient) Guard(operation func() error) error {
+	return ec.GuardContext(context.Background(), operation)
+}
+
+// GuardContext is Guard for an operation made on behalf of ctx. Operations that fail
+// because ctx was cancelled or timed out are not counted against Elasticsearch, so
+// callers giving up cannot open the breaker.
+func (ec *ElasticClient) GuardContext(ctx context.Context, operation func() error) error {
+	if ec.breaker == nil {
+		return operation()
+	}
+	return ec.breaker.ExecuteContext(ctx, operation)
 }
 
 // CreateIndex creates Elasticsearch Index if it doesn't already exist. Indexes consist of a name and must be provided with a context. The index created has default indexers and tokenizers.
@@ -39,33 +59,52 @@ func (ec *ElasticClient) CreateIndex(ctx context.Context, name string) error {
 // a index may need. In many cases using the CreateIndex function is sufficient.
 // https://www.elastic.co/guide/en/elasticsearch/reference/current/indices-create-index.html
 func (ec *ElasticClient) CreateIndexWithSettings(ctx context.Context, name string, settings string) error {
-	exists, err := ec.Client.IndexExists(name).Do(ctx)
-	if err != nil {
-		return err
-	}
-	if !exists {
-		createdIndexResults, err := ec.Client.CreateIndex(name).BodyString(settings).Do(ctx)
+	return ec.GuardContext(ctx, func() error {
+		exists, err := ec.Client.IndexExists(name).Do(ctx)
 		if err != nil {
 			return err
 		}
-		if !createdIndexResults.Acknowledged {
-			return fmt.Errorf("index was never acknowledged")
+		if !exists {
+			createdIndexResults, err := ec.Client.CreateIndex(name).BodyString(settings).Do(ctx)
+			if err != nil {
+				return err
+			}
+			if !createdIndexResults.Acknowledged {
+				return fmt.Errorf("index was never acknowledged")
+			}
 		}
-	}
-	return err
+		return err
+	})
 }
 
 // DeleteIndex deletes Elasticsearch Index.
 // Should not be used called outside of local environment or without caution and intention.
 func (ec *ElasticClient) DeleteIndex(ctx context.Context, name string) error {
-	deleteIndex, err := ec.Client.DeleteIndex(name).Do(ctx)
-	if err != nil {
+	return ec.GuardContext(ctx, func() error {
+		deleteIndex, err := ec.Client.DeleteIndex(name).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if !deleteIndex.Acknowledged {
+			return fmt.Errorf("index deletion was never acknowledged")
+		}
 		return err
-	}
-	if !deleteIndex.Acknowledged {
-		return fmt.Errorf("index deletion was never acknowledged")
-	}
-	return err
+	})
+}
+
+// PingContext checks the health of the Elasticsearch cluster, returning an error if it
+// is unreachable or its status is red. It implements health.Pinger for service checks.
+func (ec *ElasticClient) PingContext(ctx context.Context) error {
+	return ec.GuardContext(ctx, func() error {
+		health, err := ec.Client.ClusterHealth().Do(ctx)
+		if err != nil {
+			return err
+		}
+		if health.Status == "red" {
+			return fmt.Errorf("cluster %s status is red", health.ClusterName)
+		}
+		return nil
+	})
 }
 
 // AddIndexMapping adds an explicit mapping to an existing recordType within indexName.
@@ -73,16 +112,15 @@ func (ec *ElasticClient) DeleteIndex(ctx context.Context, name string) error {
 // Most indexes should have an explicit mapping to ensure that records are enforced to a specific schema
 func (ec *ElasticClient) AddIndexMapping(ctx context.Context, indexName string, recordType string, mapping string) error {
 	params := make(url.Values)
-	_, err := ec.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
-		Method: "PUT",
-		Path:   fmt.Sprintf("/%s/_mapping/%s", indexName, recordType),
-		Params: params,
-		Body:   mapping,
-	})
-	if err != nil {
+	return ec.GuardContext(ctx, func() error {
+		_, err := ec.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+			Method: "PUT",
+			Path:   fmt.Sprintf("/%s/_mapping/%s", indexName, recordType),
+			Params: params,
+			Body:   mapping,
+		})
 		return err
-	}
-	return err
+	})
 }
 
 // NewElasticClient returns a new client for Elasticsearch, local or hosted through AWS.
@@ -114,7 +152,8 @@ func NewElasticClient(config ElasticConfig) (ElasticClient, error) {
 		_ = debugFunc(client)
 	}
 	return ElasticClient{
-		client,
-		config.Logger,
+		Client:  client,
+		Logger:  config.Logger,
+		breaker: config.Breaker,
 	}, err
 }
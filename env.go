@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	urlType      = reflect.TypeOf(url.URL{})
+	urlPtrType   = reflect.TypeOf(&url.URL{})
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+const (
+	// envTag is the struct tag naming the environment variable for a field,
+	// optionally followed by ",required"
+	envTag = "env"
+	// defaultTag is the struct tag holding the value to use when the
+	// environment variable is not set
+	defaultTag = "default"
+	// requiredIfTag is the struct tag naming another environment variable which,
+	// when set to a true value, makes the field's environment variable required
+	requiredIfTag = "requiredIf"
+)
+
+var (
+	// ErrEnvNotSet is the error reported for a required environment variable that is not set
+	ErrEnvNotSet = errors.New("required but not set")
+	// ErrEnvInvalidTarget is returned by LoadEnv when it is not passed a pointer to a struct
+	ErrEnvInvalidTarget = errors.New("LoadEnv requires a non nil pointer to a struct")
+)
+
+// EnvVarError describes a problem loading a single environment variable into a struct field.
+type EnvVarError struct {
+	Name  string // The environment variable identifier
+	Field string // The struct field the variable was being loaded into
+	Err   error  // The problem encountered
+}
+
+func (e *EnvVarError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", e.Name, e.Field, e.Err)
+}
+
+func (e *EnvVarError) Unwrap() error {
+	return e.Err
+}
+
+// EnvError aggregates every problem encountered by LoadEnv.
+type EnvError struct {
+	Errors []*EnvVarError
+}
+
+func (e *EnvError) Error() string {
+	problems := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		problems = append(problems, err.Error())
+	}
+	return fmt.Sprintf("%d environment variable(s) missing or invalid: %s", len(e.Errors), strings.Join(problems, "; "))
+}
+
+// envField is a struct field tagged for loading from the environment.
+type envField struct {
+	value        reflect.Value
+	path         string
+	name         string
+	required     bool
+	requiredIf   string
+	defaultValue string
+	hasDefault   bool
+	raw          string
+	set          bool
+	failed       bool
+}
+
+// LoadEnv populates the exported fields of the struct pointed to by config from
+// environment variables named by their `env` struct tags, e.g.
+//
+//	type Config struct {
+//		Address   string `env:"DB_ADDRESS,required"`
+//		EnableTLS bool   `env:"DB_ENABLE_TLS" default:"false"`
+//		CertPath  string `env:"DB_CERT_PATH" requiredIf:"DB_ENABLE_TLS"`
+//	}
+//
+// Besides strings, bools and numbers, fields may be comma separated []string
+// lists, time.Duration, url.URL (or *url.URL), ByteSize or base64 encoded []byte
+// values. Values may be read from files as described by EnvFileSuffix.
+// Fields with a `default` tag take that value when the variable is not set.
+// Fields with a `requiredIf` tag are required when the named variable is set
+// (directly or through a default) to a true value. Untagged struct fields are
+// loaded recursively. Rather than stopping at the first problem, LoadEnv returns
+// an *EnvError listing every missing or malformed variable.
+func LoadEnv(config interface{}) error {
+	target := reflect.ValueOf(config)
+	if target.Kind() != reflect.Ptr || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return ErrEnvInvalidTarget
+	}
+	var fields []*envField
+	collectEnvFields(target.Elem(), target.Elem().Type().Name(), &fields)
+	// Resolve every raw value first so requiredIf can refer to the defaults of other fields
+	resolved := map[string]string{}
+	envErr := &EnvError{}
+	for _, field := range fields {
+		value, ok, err := lookupEnv(field.name)
+		if err != nil {
+			envErr.Errors = append(envErr.Errors, &EnvVarError{Name: field.name, Field: field.path, Err: err})
+			field.failed = true
+			continue
+		}
+		if ok {
+			field.raw, field.set = value, true
+		} else if field.hasDefault {
+			field.raw, field.set = field.defaultValue, true
+		}
+		if field.set {
+			resolved[field.name] = field.raw
+		}
+	}
+	for _, field := range fields {
+		if field.failed {
+			continue
+		}
+		if !field.set {
+			if field.required || field.requiredBy(resolved) {
+				envErr.Errors = append(envErr.Errors, &EnvVarError{Name: field.name, Field: field.path, Err: ErrEnvNotSet})
+			}
+			continue
+		}
+		if err := setEnvValue(field.value, field.raw); err != nil {
+			envErr.Errors = append(envErr.Errors, &EnvVarError{Name: field.name, Field: field.path, Err: err})
+		}
+	}
+	if len(envErr.Errors) > 0 {
+		return envErr
+	}
+	return nil
+}
+
+// requiredBy reports whether the variable this field's requiredIf tag refers
+// to is set to a true value.
+func (field *envField) requiredBy(resolved map[string]string) bool {
+	if field.requiredIf == "" {
+		return false
+	}
+	value, ok := resolved[field.requiredIf]
+	if !ok {
+		value, ok, _ = lookupEnv(field.requiredIf)
+	}
+	if !ok {
+		return false
+	}
+	active, err := strconv.ParseBool(value)
+	return err == nil && active
+}
+
+// collectEnvFields walks the fields of structValue, appending any tagged for
+// loading from the environment to fields and recursing into untagged structs.
+func collectEnvFields(structValue reflect.Value, path string, fields *[]*envField) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		if structField.PkgPath != "" {
+			// Unexported fields can not be set
+			continue
+		}
+		fieldPath := path + "." + structField.Name
+		tag, tagged := structField.Tag.Lookup(envTag)
+		if !tagged {
+			if structField.Type.Kind() == reflect.Struct && structField.Type != urlType {
+				collectEnvFields(structValue.Field(i), fieldPath, fields)
+			}
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		field := &envField{
+			value: structValue.Field(i),
+			path:  fieldPath,
+			name:  strings.TrimSpace(parts[0]),
+		}
+		for _, option := range parts[1:] {
+			if strings.TrimSpace(option) == "required" {
+				field.required = true
+			}
+		}
+		field.requiredIf = structField.Tag.Get(requiredIfTag)
+		field.defaultValue, field.hasDefault = structField.Tag.Lookup(defaultTag)
+		*fields = append(*fields, field)
+	}
+}
+
+// setEnvValue parses raw according to the kind of field and stores the result in field.
+func setEnvValue(field reflect.Value, raw string) error {
+	switch field.Type() {
+	case durationType:
+		value, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("could not be parsed to a duration: %q", raw)
+		}
+		field.SetInt(int64(value))
+		return nil
+	case byteSizeType:
+		value, err := parseByteSize(raw)
+		if err != nil {
+			return fmt.Errorf("could not be parsed to a byte size: %q", raw)
+		}
+		field.SetInt(int64(value))
+		return nil
+	case urlType, urlPtrType:
+		value, err := parseURL(raw)
+		if err != nil {
+			return fmt.Errorf("could not be parsed to a URL: %q", raw)
+		}
+		if field.Type() == urlType {
+			field.Set(reflect.ValueOf(*value))
+		} else {
+			field.Set(reflect.ValueOf(value))
+		}
+		return nil
+	case bytesType:
+		value, err := decodeBase64(raw)
+		if err != nil {
+			return errors.New("could not be decoded from base64")
+		}
+		field.SetBytes(value)
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("could not be parsed to a bool: %q", raw)
+		}
+		field.SetBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("can not be cast to %s: %q", field.Type(), raw)
+		}
+		field.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("can not be cast to %s: %q", field.Type(), raw)
+		}
+		field.SetUint(value)
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("can not be cast to float: %q", raw)
+		}
+		field.SetFloat(value)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		// Set each element so named element types (e.g. []MyString) are supported
+		items := splitList(raw)
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for index, item := range items {
+			slice.Index(index).SetString(item)
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, trimming whitespace and dropping empty items.
+func splitList(raw string) []string {
+	items := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testTLSConfig struct {
+	Enabled  bool   `env:"TEST_TLS_ENABLED" default:"false"`
+	CertPath string `env:"TEST_TLS_CERT_PATH" requiredIf:"TEST_TLS_ENABLED"`
+}
+
+type testEnvConfig struct {
+	Address  string   `env:"TEST_ADDRESS,required"`
+	Port     int      `env:"TEST_PORT" default:"5432"`
+	Ratio    float64  `env:"TEST_RATIO"`
+	Brokers  []string `env:"TEST_BROKERS"`
+	TLS      testTLSConfig
+	Untagged string
+}
+
+func TestLoadEnvPopulatesStruct(t *testing.T) {
+	t.Setenv("TEST_ADDRESS", "db:5432")
+	t.Setenv("TEST_RATIO", "0.5")
+	t.Setenv("TEST_BROKERS", "kafka-1:9092, kafka-2:9092,")
+	var config testEnvConfig
+	if err := LoadEnv(&config); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	expected := testEnvConfig{
+		Address: "db:5432",
+		Port:    5432,
+		Ratio:   0.5,
+		Brokers: []string{"kafka-1:9092", "kafka-2:9092"},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+}
+
+type testTopic string
+
+func TestLoadEnvPopulatesNamedStringSlices(t *testing.T) {
+	t.Setenv("TEST_TOPICS", "events, audit")
+	var config struct {
+		Topics []testTopic `env:"TEST_TOPICS"`
+	}
+	if err := LoadEnv(&config); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if expected := []testTopic{"events", "audit"}; !reflect.DeepEqual(config.Topics, expected) {
+		t.Errorf("Expected %v, got %v", expected, config.Topics)
+	}
+}
+
+func TestLoadEnvReportsEveryProblem(t *testing.T) {
+	t.Setenv("TEST_PORT", "not-a-port")
+	t.Setenv("TEST_TLS_ENABLED", "true")
+	var config testEnvConfig
+	err := LoadEnv(&config)
+	var envErr *EnvError
+	if !errors.As(err, &envErr) {
+		t.Fatalf("Expected an EnvError, got %v", err)
+	}
+	problems := map[string]bool{}
+	for _, varErr := range envErr.Errors {
+		problems[varErr.Name] = true
+	}
+	for _, name := range []string{"TEST_ADDRESS", "TEST_PORT", "TEST_TLS_CERT_PATH"} {
+		if !problems[name] {
+			t.Errorf("Expected %s to be reported in %s", name, err)
+		}
+	}
+	if len(envErr.Errors) != 3 {
+		t.Errorf("Expected exactly 3 problems, got %s", err)
+	}
+	if !errors.Is(envErr.Errors[0], ErrEnvNotSet) {
+		t.Errorf("Expected missing variable to wrap ErrEnvNotSet, got %s", envErr.Errors[0])
+	}
+}
+
+type testTypedEnvConfig struct {
+	Timeout  time.Duration `env:"TEST_TIMEOUT" default:"1m30s"`
+	MaxBody  ByteSize      `env:"TEST_MAX_BODY" default:"10MiB"`
+	Endpoint *url.URL      `env:"TEST_ENDPOINT,required"`
+	Key      []byte        `env:"TEST_KEY,required"`
+	Secret   string        `env:"TEST_SECRET,required"`
+}
+
+func TestLoadEnvParsesTypedValuesAndSecretFiles(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretPath, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_ENDPOINT", "https://sqs.us-west-2.amazonaws.com")
+	t.Setenv("TEST_KEY", "AQID")
+	t.Setenv("TEST_SECRET"+EnvFileSuffix, secretPath)
+	var config testTypedEnvConfig
+	if err := LoadEnv(&config); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if config.Timeout != 90*time.Second || config.MaxBody != 10<<20 {
+		t.Errorf("Unexpected defaults %s and %d", config.Timeout, config.MaxBody)
+	}
+	if config.Endpoint.Host != "sqs.us-west-2.amazonaws.com" || !bytes.Equal(config.Key, []byte{1, 2, 3}) {
+		t.Errorf("Unexpected endpoint %s or key %v", config.Endpoint, config.Key)
+	}
+	if config.Secret != "s3cr3t" {
+		t.Errorf("Expected secret to be read from file, got %q", config.Secret)
+	}
+	if _, err := LookupEnvByteSize("TEST_ENDPOINT"); err == nil {
+		t.Errorf("Expected an error parsing a URL as a byte size")
+	}
+	for _, overflowing := range []string{"9223372036854775808", "8388608TiB"} {
+		t.Setenv("TEST_MAX_BODY", overflowing)
+		if size, err := LookupEnvByteSize("TEST_MAX_BODY"); err == nil {
+			t.Errorf("Expected %s to overflow, got %d", overflowing, size)
+		}
+	}
+}
+
+func TestLookupEnvOrDefaultReportsUnreadableSecretFiles(t *testing.T) {
+	t.Setenv("TEST_SECRET"+EnvFileSuffix, filepath.Join(t.TempDir(), "missing"))
+	value, err := LookupEnvOrDefault("TEST_SECRET", "fallback")
+	if err == nil {
+		t.Errorf("Expected an error reading a missing secret file")
+	}
+	if value != "fallback" || EnvOrDefault("TEST_SECRET", "fallback") != "fallback" {
+		t.Errorf("Expected the fallback value, got %q", value)
+	}
+	if value, err := LookupEnvOrDefault("TEST_UNSET", "fallback"); err != nil || value != "fallback" {
+		t.Errorf("Expected the fallback for an unset variable, got %q and %v", value, err)
+	}
+}
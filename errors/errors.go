@@ -0,0 +1,225 @@
+// Package errors provides a typed error model for services, where each error
+// carries a machine readable code, the HTTP status it maps to, a message that is
+// safe to show to callers, internal detail for logs and the underlying cause.
+package errors
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Code is a machine readable identifier for a class of error.
+type Code string
+
+// Codes for the classes of error services commonly return.
+const (
+	CodeInvalidArgument    Code = "invalid_argument"
+	CodeUnauthenticated    Code = "unauthenticated"
+	CodePermissionDenied   Code = "permission_denied"
+	CodeNotFound           Code = "not_found"
+	CodeConflict           Code = "conflict"
+	CodeRequestTooLarge    Code = "request_too_large"
+	CodeRateLimited        Code = "rate_limited"
+	CodeCanceled           Code = "canceled"
+	CodeInternal           Code = "internal"
+	CodeUnavailable        Code = "unavailable"
+	CodeDeadlineExceeded   Code = "deadline_exceeded"
+	CodeUnsupportedRequest Code = "unsupported_request"
+)
+
+// statusCodes maps each known Code to its default HTTP status.
+var statusCodes = map[Code]int{
+	CodeInvalidArgument:    http.StatusBadRequest,
+	CodeUnauthenticated:    http.StatusUnauthorized,
+	CodePermissionDenied:   http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeConflict:           http.StatusConflict,
+	CodeRequestTooLarge:    http.StatusRequestEntityTooLarge,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeCanceled:           499, // Client closed request
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeDeadlineExceeded:   http.StatusGatewayTimeout,
+	CodeUnsupportedRequest: http.StatusUnsupportedMediaType,
+}
+
+// Status returns the default HTTP status for code, or 500 for unknown codes.
+func (c Code) Status() int {
+	if status, ok := statusCodes[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// FieldError describes a problem with a single field of a request, identified by
+// its path in the request document (e.g. "user.addresses[0].zip").
+type FieldError struct {
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Error is a typed error carrying everything needed to respond to and log a failure.
+type Error struct {
+	Code    Code         // Machine readable class of the error
+	Status  int          // HTTP status to respond with
+	Message string       // Message which is safe to return to callers
+	Fields  []FieldError // Problems with individual request fields, which are safe to return to callers
+	Detail  string       // Internal detail which is logged but never returned to callers
+	Cause   error        // Underlying error (if any)
+}
+
+// New returns an Error with code, the code's default HTTP status and the public message.
+func New(code Code, message string) *Error {
+	return &Error{
+		Code:    code,
+		Status:  code.Status(),
+		Message: message,
+	}
+}
+
+// Wrap returns an Error with code and the public message caused by cause.
+func Wrap(cause error, code Code, message string) *Error {
+	err := New(code, message)
+	err.Cause = cause
+	return err
+}
+
+// Error returns the full description of the error including internal detail
+// and cause, for logging. Use Message for text that is returned to callers.
+func (e *Error) Error() string {
+	parts := []string{string(e.Code)}
+	if e.Message != "" {
+		parts = append(parts, e.Message)
+	}
+	for _, field := range e.Fields {
+		parts = append(parts, field.Field+" "+field.Reason)
+	}
+	if e.Detail != "" {
+		parts = append(parts, e.Detail)
+	}
+	if e.Cause != nil {
+		parts = append(parts, e.Cause.Error())
+	}
+	return strings.Join(parts, ": ")
+}
+
+// Unwrap returns the cause of the error.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is an *Error with the same code, allowing errors
+// to be matched by class, e.g. errors.Is(err, errors.NotFound("")).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetail returns a copy of the error with internal detail formatted from format and args.
+func (e *Error) WithDetail(format string, args ...interface{}) *Error {
+	copy := *e
+	copy.Detail = fmt.Sprintf(format, args...)
+	return &copy
+}
+
+// WithFields returns a copy of the error with fields appended to its field errors.
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	copy := *e
+	copy.Fields = append(append([]FieldError{}, e.Fields...), fields...)
+	return &copy
+}
+
+// WithStatus returns a copy of the error which responds with the HTTP status.
+func (e *Error) WithStatus(status int) *Error {
+	copy := *e
+	copy.Status = status
+	return &copy
+}
+
+// WithCause returns a copy of the error caused by cause.
+func (e *Error) WithCause(cause error) *Error {
+	copy := *e
+	copy.Cause = cause
+	return &copy
+}
+
+// InvalidArgument returns an Error for a request which is malformed or fails validation.
+func InvalidArgument(message string) *Error {
+	return New(CodeInvalidArgument, message)
+}
+
+// Unauthenticated returns an Error for a request without valid credentials.
+func Unauthenticated(message string) *Error {
+	return New(CodeUnauthenticated, message)
+}
+
+// PermissionDenied returns an Error for a request whose caller lacks permission.
+func PermissionDenied(message string) *Error {
+	return New(CodePermissionDenied, message)
+}
+
+// NotFound returns an Error for a request for a resource which does not exist.
+func NotFound(message string) *Error {
+	return New(CodeNotFound, message)
+}
+
+// Conflict returns an Error for a request which conflicts with the current state of a resource.
+func Conflict(message string) *Error {
+	return New(CodeConflict, message)
+}
+
+// Unavailable returns an Error for a request which can not be served because a dependency is unavailable.
+func Unavailable(cause error, message string) *Error {
+	return Wrap(cause, CodeUnavailable, message)
+}
+
+// Internal returns an Error for an unexpected failure, with a generic public message.
+func Internal(cause error) *Error {
+	return Wrap(cause, CodeInternal, "Internal server error")
+}
+
+// From returns err as an *Error. Errors which are not already typed are mapped to
+// CodeCanceled or CodeDeadlineExceeded for context errors and CodeInternal otherwise,
+// keeping err as the cause so it is logged but never shown to callers.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var typed *Error
+	if stderrors.As(err, &typed) {
+		return typed
+	}
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		return Wrap(err, CodeCanceled, "Request canceled")
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return Wrap(err, CodeDeadlineExceeded, "Request timed out")
+	}
+	return Internal(err)
+}
+
+// CodeOf returns the code of err, CodeInternal for untyped errors or "" for nil.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	return From(err).Code
+}
+
+// Is reports whether any error in err's chain matches target, see the standard library errors.Is.
+func Is(err error, target error) bool {
+	return stderrors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, see the standard library errors.As.
+func As(err error, target interface{}) bool {
+	return stderrors.As(err, target)
+}
+
+// Unwrap returns the result of calling the Unwrap method on err, see the standard library errors.Unwrap.
+func Unwrap(err error) error {
+	return stderrors.Unwrap(err)
+}
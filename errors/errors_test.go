@@ -0,0 +1,54 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorWrapsCauseAndMatchesByCode(t *testing.T) {
+	cause := fmt.Errorf("row 42 missing")
+	err := fmt.Errorf("loading account: %w", Wrap(cause, CodeNotFound, "Account not found").WithDetail("account %d", 42))
+	if !Is(err, NotFound("")) || Is(err, Conflict("")) {
+		t.Errorf("Expected error to match by code only")
+	}
+	if !Is(err, cause) {
+		t.Errorf("Expected error to unwrap to its cause")
+	}
+	typed := From(err)
+	if typed.Status != http.StatusNotFound || typed.Message != "Account not found" || typed.Detail != "account 42" {
+		t.Errorf("Unexpected typed error %+v", typed)
+	}
+	if typed.Error() != "not_found: Account not found: account 42: row 42 missing" {
+		t.Errorf("Unexpected error text %q", typed.Error())
+	}
+}
+
+func TestFromMapsUntypedErrors(t *testing.T) {
+	cases := map[error]Code{
+		fmt.Errorf("boom"):       CodeInternal,
+		context.Canceled:         CodeCanceled,
+		context.DeadlineExceeded: CodeDeadlineExceeded,
+		nil:                      "",
+	}
+	for err, code := range cases {
+		if got := CodeOf(err); got != code {
+			t.Errorf("Expected %v to map to %q, got %q", err, code, got)
+		}
+	}
+	if message := From(fmt.Errorf("secret database password")).Message; message != "Internal server error" {
+		t.Errorf("Expected untyped error text to be hidden, got %q", message)
+	}
+}
+
+func TestWithFieldsDoesNotModifyOriginal(t *testing.T) {
+	original := InvalidArgument("Invalid request").WithFields(FieldError{Field: "name", Reason: "required"})
+	extended := original.WithFields(FieldError{Field: "email", Reason: "invalid format"})
+	if len(original.Fields) != 1 || len(extended.Fields) != 2 {
+		t.Errorf("Expected 1 and 2 field errors, got %d and %d", len(original.Fields), len(extended.Fields))
+	}
+	if extended.Fields[1].Field != "email" {
+		t.Errorf("Expected appended field error for email, got %+v", extended.Fields[1])
+	}
+}
@@ -1,19 +1,86 @@
 package utils
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
 
 	"golang.org/x/crypto/blake2b"
 )
 
+const (
+	// DefaultHashSize is the size in bytes of digests produced by HashAndEncodeString
+	DefaultHashSize = 32
+)
+
 // HashAndEncodeString uses blake2b to hash the provided string and
 // returns the result in a base64 url encoded format. Returning an error if any.
 func HashAndEncodeString(toHash string) (string, error) {
-	h, err := blake2b.New(32, nil)
+	return HashAndEncodeReader(strings.NewReader(toHash), DefaultHashSize, nil)
+}
+
+// HashAndEncodeReader uses blake2b to hash everything read from r into a digest
+// of size bytes (1 to 64), keyed with key (up to 64 bytes, nil for an unkeyed hash),
+// and returns the result in a base64 url encoded format. Returning an error if any.
+func HashAndEncodeReader(r io.Reader, size int, key []byte) (string, error) {
+	digest, err := hashReader(r, size, key)
 	if err != nil {
 		return "", err
 	}
-	_, err = h.Write([]byte(toHash))
-	noteName := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
-	return noteName, err
+	return base64.RawURLEncoding.EncodeToString(digest), nil
+}
+
+// MACAndEncodeString uses keyed blake2b to compute a message authentication code
+// for the provided message, e.g. for signing tokens or webhook payloads,
+// and returns the result in a base64 url encoded format. Returning an error if any.
+func MACAndEncodeString(key []byte, message string) (string, error) {
+	return HashAndEncodeReader(strings.NewReader(message), DefaultHashSize, key)
+}
+
+// VerifyHash reports whether encodedHash is the base64 url encoded blake2b digest
+// of DefaultHashSize bytes of toHash, keyed with key (nil for an unkeyed hash).
+// The comparison is made in constant time. Returning an error if encodedHash
+// is not a valid encoded digest of DefaultHashSize bytes.
+func VerifyHash(encodedHash string, toHash string, key []byte) (bool, error) {
+	return VerifyHashReader(encodedHash, strings.NewReader(toHash), key)
+}
+
+// VerifyHashReader reports whether encodedHash is the base64 url encoded blake2b
+// digest of DefaultHashSize bytes of everything read from r, keyed with key (nil for an unkeyed hash).
+// The comparison is made in constant time. Returning an error if encodedHash is not
+// a valid encoded digest of DefaultHashSize bytes or r can not be read.
+func VerifyHashReader(encodedHash string, r io.Reader, key []byte) (bool, error) {
+	return VerifyHashReaderSize(encodedHash, r, DefaultHashSize, key)
+}
+
+// VerifyHashReaderSize is VerifyHashReader for digests of size bytes (1 to 64).
+// The size is never taken from encodedHash, as a caller supplying a shorter digest
+// would otherwise need far fewer guesses to forge a MAC.
+func VerifyHashReaderSize(encodedHash string, r io.Reader, size int, key []byte) (bool, error) {
+	expected, err := base64.RawURLEncoding.DecodeString(encodedHash)
+	if err != nil {
+		return false, fmt.Errorf("invalid encoded hash: %w", err)
+	}
+	if len(expected) != size {
+		return false, fmt.Errorf("invalid encoded hash: expected %d bytes, got %d", size, len(expected))
+	}
+	actual, err := hashReader(r, size, key)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(expected, actual) == 1, nil
+}
+
+// hashReader returns the blake2b digest of size bytes of everything read from r.
+func hashReader(r io.Reader, size int, key []byte) ([]byte, error) {
+	h, err := blake2b.New(size, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHashAndEncodeStringIsStable(t *testing.T) {
+	// Digest stored by callers before keyed and streaming hashing were supported
+	const expected = "MMeW2zL6w-uS65yNGH_3hKDdh3rSVNZO13OCaQfW3EY"
+	hash, err := HashAndEncodeString("tozny")
+	if err != nil || hash != expected {
+		t.Errorf("Expected %s, got %s (error %v)", expected, hash, err)
+	}
+	streamed, err := HashAndEncodeReader(bytes.NewBufferString("tozny"), DefaultHashSize, nil)
+	if err != nil || streamed != hash {
+		t.Errorf("Expected streamed hash %s to equal %s (error %v)", streamed, hash, err)
+	}
+}
+
+func TestVerifyHash(t *testing.T) {
+	key := []byte("webhook signing key")
+	mac, err := MACAndEncodeString(key, "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyHash(mac, "payload", key); !ok || err != nil {
+		t.Errorf("Expected MAC to verify, got %t (error %v)", ok, err)
+	}
+	if ok, _ := VerifyHash(mac, "payload", []byte("other key")); ok {
+		t.Errorf("Expected MAC with the wrong key not to verify")
+	}
+	if ok, _ := VerifyHash(mac, "tampered", key); ok {
+		t.Errorf("Expected MAC of a different message not to verify")
+	}
+	short, err := HashAndEncodeReader(bytes.NewBufferString("payload"), 16, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyHashReaderSize(short, bytes.NewBufferString("payload"), 16, nil); !ok || err != nil {
+		t.Errorf("Expected 16 byte digest to verify, got %t (error %v)", ok, err)
+	}
+	if ok, err := VerifyHash(short, "payload", nil); ok || err == nil {
+		t.Errorf("Expected 16 byte digest to be rejected at the default size, got %t (error %v)", ok, err)
+	}
+	truncated := mac[:2]
+	if ok, err := VerifyHash(truncated, "payload", key); ok || err == nil {
+		t.Errorf("Expected truncated MAC to be rejected, got %t (error %v)", ok, err)
+	}
+	if _, err := VerifyHash("not base64!", "payload", nil); err == nil {
+		t.Errorf("Expected an error for an invalid encoded hash")
+	}
+}
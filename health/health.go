@@ -0,0 +1,223 @@
+// Package health provides a registry of named dependency checks (e.g. database,
+// cache, queue and stream connectivity) which are run concurrently with timeouts,
+// cached, and summarized in a report for service check endpoints.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+const (
+	// DefaultTimeout is how long a check may run before it fails
+	DefaultTimeout = 3 * time.Second
+	// DefaultCacheTTL is how long check results are reused before checks are run again
+	DefaultCacheTTL = 5 * time.Second
+	// StatusPass is the status of a passing check or report
+	StatusPass = "pass"
+	// StatusFail is the status of a failing check or report
+	StatusFail = "fail"
+)
+
+var (
+	// ErrCheckPanicked is reported for checks which panic
+	ErrCheckPanicked = errors.New("check panicked")
+)
+
+// Check reports the health of a dependency, returning nil if it is healthy.
+// Checks must return promptly once ctx is done.
+type Check func(ctx context.Context) error
+
+// Pinger is the interface implemented by clients which can check connectivity to
+// their backend, e.g. database.DB, queue.SQSQueue and stream.KafkaStream.
+// Register a Pinger with its PingContext method value.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Config wraps configuration for a Registry. Zero values use the package defaults.
+type Config struct {
+	Timeout  time.Duration // Default time each check may run before it fails
+	CacheTTL time.Duration // How long results are reused before checks are run again, negative disables caching
+	Clock    clock.Clock   // Source of time for latency and caching, nil uses the system clock
+}
+
+// CheckOption configures an individual check in a Registry.
+type CheckOption func(*registeredCheck)
+
+// WithTimeout sets how long a check may run before it fails, overriding the registry default.
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *registeredCheck) {
+		c.timeout = timeout
+	}
+}
+
+// Result is the outcome of running a single check.
+type Result struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the outcome of running every check in a registry.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Healthy reports whether every check passed.
+func (r Report) Healthy() bool {
+	return r.Status == StatusPass
+}
+
+// WithoutErrors returns a copy of the report with the error of every check removed,
+// for reporting to clients which should not see details of the service's dependencies.
+func (r Report) WithoutErrors() Report {
+	checks := make([]Result, len(r.Checks))
+	for index, result := range r.Checks {
+		result.Error = ""
+		checks[index] = result
+	}
+	return Report{Status: r.Status, Checks: checks}
+}
+
+// registeredCheck is a check with its configuration and cached result.
+type registeredCheck struct {
+	name    string
+	check   Check
+	timeout time.Duration
+	mutex   sync.Mutex // Held while running so concurrent reports share one run
+	result  Result
+	expires time.Time
+}
+
+// Registry holds named dependency checks.
+type Registry struct {
+	config Config
+	clock  clock.Clock
+	mutex  sync.RWMutex
+	checks map[string]*registeredCheck
+}
+
+// NewRegistry returns a new empty Registry configured with the provided config.
+func NewRegistry(config Config) *Registry {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = DefaultCacheTTL
+	}
+	return &Registry{
+		config: config,
+		clock:  clock.OrNew(config.Clock),
+		checks: map[string]*registeredCheck{},
+	}
+}
+
+// Register adds check to the registry under name, replacing any check already registered with that name.
+func (r *Registry) Register(name string, check Check, options ...CheckOption) {
+	registered := &registeredCheck{
+		name:    name,
+		check:   check,
+		timeout: r.config.Timeout,
+	}
+	for _, option := range options {
+		option(registered)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.checks[name] = registered
+}
+
+// Check runs every registered check concurrently, reusing results which are still cached,
+// and returns a report sorted by check name which passes only if every check passed.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mutex.RLock()
+	checks := make([]*registeredCheck, 0, len(r.checks))
+	for _, check := range r.checks {
+		checks = append(checks, check)
+	}
+	r.mutex.RUnlock()
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	report := Report{
+		Status: StatusPass,
+		Checks: make([]Result, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for index, check := range checks {
+		wg.Add(1)
+		go func(index int, check *registeredCheck) {
+			defer wg.Done()
+			report.Checks[index] = r.run(ctx, check)
+		}(index, check)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		if result.Status != StatusPass {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// run returns the cached result of check, running it if the cached result has expired.
+// Results are not cached if ctx is done, since a check failing only because the caller
+// went away says nothing about the dependency.
+func (r *Registry) run(ctx context.Context, check *registeredCheck) Result {
+	check.mutex.Lock()
+	defer check.mutex.Unlock()
+	if r.clock.Now().Before(check.expires) {
+		return check.result
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, check.timeout)
+	defer cancel()
+	start := r.clock.Now()
+	err := runCheck(checkCtx, check.check)
+	result := Result{
+		Name:      check.name,
+		Status:    StatusPass,
+		LatencyMS: float64(r.clock.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	if ctx.Err() != nil {
+		return result
+	}
+	check.result = result
+	if r.config.CacheTTL > 0 {
+		check.expires = r.clock.Now().Add(r.config.CacheTTL)
+	}
+	return result
+}
+
+// runCheck runs check, failing it if it panics or does not return before ctx is done.
+func runCheck(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- fmt.Errorf("%w: %v", ErrCheckPanicked, recovered)
+			}
+		}()
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+func TestRegistryReportsAndCachesResults(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	registry := NewRegistry(Config{CacheTTL: time.Minute, Clock: fakeClock})
+	calls := 0
+	registry.Register("database", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	registry.Register("cache", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	report := registry.Check(context.Background())
+	if report.Healthy() || len(report.Checks) != 2 {
+		t.Fatalf("Expected failing report with two checks, got %+v", report)
+	}
+	if cache := report.Checks[0]; cache.Name != "cache" || cache.Status != StatusFail || cache.Error != "connection refused" {
+		t.Errorf("Unexpected cache result %+v", cache)
+	}
+	if database := report.Checks[1]; database.Name != "database" || database.Status != StatusPass {
+		t.Errorf("Unexpected database result %+v", database)
+	}
+	registry.Check(context.Background())
+	if calls != 1 {
+		t.Errorf("Expected cached result to be reused, check ran %d times", calls)
+	}
+	fakeClock.Advance(time.Minute)
+	registry.Check(context.Background())
+	if calls != 2 {
+		t.Errorf("Expected check to run again once the cache expired, check ran %d times", calls)
+	}
+}
+
+func TestRegistryTimesOutAndRecoversChecks(t *testing.T) {
+	registry := NewRegistry(Config{CacheTTL: -1})
+	registry.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	}, WithTimeout(10*time.Millisecond))
+	registry.Register("panics", func(ctx context.Context) error {
+		panic("boom")
+	})
+	start := time.Now()
+	report := registry.Check(context.Background())
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected slow check to be abandoned at its timeout")
+	}
+	if report.Checks[0].Error != ErrCheckPanicked.Error()+": boom" {
+		t.Errorf("Expected panicking check to fail, got %+v", report.Checks[0])
+	}
+	if report.Checks[1].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Expected slow check to time out, got %+v", report.Checks[1])
+	}
+}
+
+func TestRegistryDoesNotCacheCancelledChecks(t *testing.T) {
+	registry := NewRegistry(Config{CacheTTL: time.Minute})
+	registry.Register("database", func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report := registry.Check(ctx); report.Healthy() {
+		t.Errorf("Expected a check cancelled by the caller to fail, got %+v", report)
+	}
+	if report := registry.Check(context.Background()); !report.Healthy() {
+		t.Errorf("Expected the cancelled result not to be cached, got %+v", report)
+	}
+}
+
+func TestReportWithoutErrors(t *testing.T) {
+	report := Report{Status: StatusFail, Checks: []Result{{Name: "database", Status: StatusFail, Error: "dial tcp db.internal:5432"}}}
+	public := report.WithoutErrors()
+	if public.Status != StatusFail || public.Checks[0].Error != "" || public.Checks[0].Name != "database" {
+		t.Errorf("Expected only the error to be removed, got %+v", public)
+	}
+	if report.Checks[0].Error == "" {
+		t.Errorf("Expected the original report to be unchanged")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/tozny/utils-go/requestid"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -140,15 +141,12 @@ func (sl *AltServiceLogger) Debugf(format string, v ...interface{}) {
 // Debugw, debug "with" structured data, sends a debug level log to the configured log output.
 // Severity and severity-code are set to debug level as per RFC 5424 for User-level facility
 // Where message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *AltServiceLogger) Debugw(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "DEBUG", "severity-code", "15", "requester-ip", ip)
+	v = append(v, "severity", "DEBUG", "severity-code", "15")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Debugw(message, v...)
 }
 
@@ -173,15 +171,12 @@ func (sl *AltServiceLogger) Infof(format string, v ...interface{}) {
 // Infow, info "with" structured data, sends an info level log to the configured log output.
 // Severity and severity-code are set to info level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *AltServiceLogger) Infow(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "INFO", "severity-code", "14", "requester-ip", ip)
+	v = append(v, "severity", "INFO", "severity-code", "14")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Infow(message, v...)
 }
 
@@ -206,15 +201,12 @@ func (sl *AltServiceLogger) Warnf(format string, v ...interface{}) {
 // Warnw, warn "with" structured data, sends a warn level log to the configured log output.
 // Severity and severity-code are set to warn level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *AltServiceLogger) Warnw(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "WARN", "severity-code", "12", "requester-ip", ip)
+	v = append(v, "severity", "WARN", "severity-code", "12")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Warnw(message, v...)
 }
 
@@ -238,15 +230,12 @@ func (sl *AltServiceLogger) Errorf(format string, v ...interface{}) {
 // Errorw, error "with" structured data, sends an error level log to the configured log output.
 // Severity and severity-code are set to Error level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *AltServiceLogger) Errorw(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "ERROR", "severity-code", "11", "requester-ip", ip)
+	v = append(v, "severity", "ERROR", "severity-code", "11")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Errorw(message, v...)
 }
 
@@ -270,15 +259,12 @@ func (sl *AltServiceLogger) Criticalf(format string, v ...interface{}) {
 // Criticalw, critical "with" structured data, sends an error level log to the configured log output.
 // Severity and severity-code are set to Critical level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *AltServiceLogger) CriticalW(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "CRITICAL", "severity-code", "10", "requester-ip", ip)
+	v = append(v, "severity", "CRITICAL", "severity-code", "10")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Errorw(message, v...)
 }
 
@@ -297,6 +283,19 @@ func (sl *AltServiceLogger) Printf(format string, v ...interface{}) {
 	sl.Infof(format, v...)
 }
 
+// requestFields returns the key-value pairs identifying r which are added to
+// structured logs, the caller's IP address and the request ID (if any).
+func requestFields(r *http.Request) []interface{} {
+	if r == nil {
+		return []interface{}{"requester-ip", ""}
+	}
+	fields := []interface{}{"requester-ip", getIP(r)}
+	if id := requestid.FromRequest(r); id != "" {
+		fields = append(fields, requestid.Key, id)
+	}
+	return fields
+}
+
 // getIP gets a requests IP address by reading off the forwarded-for
 // header (for proxies) and falls back to use the remote address.
 func getIP(r *http.Request) string {
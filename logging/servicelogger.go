@@ -130,15 +130,12 @@ func (sl *ServiceLogger) Debugf(format string, v ...interface{}) {
 // Debugw, debug "with" structured data, sends a debug level log to the configured log output.
 // Severity and severity-code are set to debug level as per RFC 5424 for User-level facility
 // Where message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *ServiceLogger) Debugw(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "DEBUG", "severity-code", "15", "requester-ip", ip)
+	v = append(v, "severity", "DEBUG", "severity-code", "15")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Debugw(message, v...)
 }
 
@@ -163,15 +160,12 @@ func (sl *ServiceLogger) Infof(format string, v ...interface{}) {
 // Infow, info "with" structured data, sends an info level log to the configured log output.
 // Severity and severity-code are set to info level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *ServiceLogger) Infow(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "INFO", "severity-code", "14", "requester-ip", ip)
+	v = append(v, "severity", "INFO", "severity-code", "14")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Infow(message, v...)
 }
 
@@ -196,15 +190,12 @@ func (sl *ServiceLogger) Warnf(format string, v ...interface{}) {
 // Warnw, warn "with" structured data, sends a warn level log to the configured log output.
 // Severity and severity-code are set to warn level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *ServiceLogger) Warnw(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "WARN", "severity-code", "12", "requester-ip", ip)
+	v = append(v, "severity", "WARN", "severity-code", "12")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Warnw(message, v...)
 }
 
@@ -228,15 +219,12 @@ func (sl *ServiceLogger) Errorf(format string, v ...interface{}) {
 // Errorw, error "with" structured data, sends an error level log to the configured log output.
 // Severity and severity-code are set to Error level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *ServiceLogger) Errorw(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "ERROR", "severity-code", "11", "requester-ip", ip)
+	v = append(v, "severity", "ERROR", "severity-code", "11")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Errorw(message, v...)
 }
 
@@ -260,15 +248,12 @@ func (sl *ServiceLogger) Criticalf(format string, v ...interface{}) {
 // Criticalw, critical "with" structured data, sends an error level log to the configured log output.
 // Severity and severity-code are set to Critical level as per RFC 5424 for User-level facility
 // Message is a string that is the values associated with the `message` key.
-// If r is not nil, the IP address of caller will be added to key `requester-ip`
+// If r is not nil, the IP address of caller will be added to key `requester-ip` and its request ID to key `request-id`
 // The variadic values are key-value pairs that must be string: interface{}.
 // If duplicate keys are provided the logger will output all sets though downstream log processors that
 func (sl *ServiceLogger) CriticalW(message string, r *http.Request, v ...interface{}) {
-	var ip string
-	if r != nil {
-		ip = getIP(r)
-	}
-	v = append(v, "severity", "CRITICAL", "severity-code", "10", "requester-ip", ip)
+	v = append(v, "severity", "CRITICAL", "severity-code", "10")
+	v = append(v, requestFields(r)...)
 	sl.SugaredLogger.Errorw(message, v...)
 }
 
@@ -1,23 +1,94 @@
 package utils
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// MustGetenv attempts to lookup and return the value associated with the specified environment variable identifier, panic'ing if no value is associated with that identifier
-func MustGetenv(env string) string {
-	value, ok := os.LookupEnv(env)
+const (
+	// EnvFileSuffix is appended to an environment variable identifier to name a
+	// variable holding the path of a file containing the value, following the
+	// convention used for Docker and Kubernetes secrets, e.g. DB_PASSWORD_FILE.
+	EnvFileSuffix = "_FILE"
+)
+
+var (
+	// ErrEnvZero is the error reported when a variable equals zero where explicitly not allowed
+	ErrEnvZero = errors.New("equals 0 when explicitly not allowed")
+)
+
+// ByteSize is a number of bytes, parsed from values such as "512", "64KB" or "10MiB".
+type ByteSize int64
+
+// byteSizeUnits maps supported size suffixes to their multiplier. Single letter
+// suffixes are binary, matching the convention of tools such as Docker.
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1e3,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1e6,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1e9,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TB":  1e12,
+	"TIB": 1 << 40,
+}
+
+// lookupEnv retrieves the value of the environment variable named by key,
+// reporting whether it was set. If key is not set but key with EnvFileSuffix
+// is, the value is read from the named file with any trailing newline removed.
+func lookupEnv(key string) (string, bool, error) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true, nil
+	}
+	path, ok := os.LookupEnv(key + EnvFileSuffix)
 	if !ok {
-		panic(fmt.Sprintf("Failed to find environment variable with identifier: %s\n", env))
+		return "", false, nil
 	}
-	return value
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("could not read %s%s file: %w", key, EnvFileSuffix, err)
+	}
+	return strings.TrimRight(string(contents), "\r\n"), true, nil
+}
+
+// LookupEnv attempts to lookup and return the value associated with the specified environment variable identifier
+// (or read from the file named by the identifier with EnvFileSuffix), returning an error if no value is associated with that identifier
+func LookupEnv(env string) (string, error) {
+	value, ok, err := lookupEnv(env)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("Failed to find environment variable with identifier: %s: %w", env, ErrEnvNotSet)
+	}
+	return value, nil
+}
+
+// MustGetenv attempts to lookup and return the value associated with the specified environment variable identifier, panic'ing if no value is associated with that identifier
+func MustGetenv(env string) string {
+	return mustGetenv(LookupEnv(env))
 }
 
 // TransitiveMustGetenv will (if a transitive dependnecy such as the value of another environment is set)
 //
 //	attempt to lookup the specified environment variable and return its string panicing if not provided
+//
+// Deprecated: use LoadEnv with a requiredIf struct tag instead.
 func TransitiveMustGetenv(env string, active bool) string {
 	if !active {
 		return ""
@@ -25,19 +96,30 @@ func TransitiveMustGetenv(env string, active bool) string {
 	return MustGetenv(env)
 }
 
-// MustGetenvInt attempts to lookup and return the value associated with the specified environment variable identifier and cast it to an int,
-// panic'ing if no value is associated with that identifier or it cannot be cast
-func MustGetenvInt(env string) int {
-	value := MustGetenv(env)
+// LookupEnvInt attempts to lookup and return the value associated with the specified environment variable identifier cast to an int,
+// returning an error if no value is associated with that identifier or it cannot be cast
+func LookupEnvInt(env string) (int, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return 0, err
+	}
 	intVal, err := strconv.Atoi(value)
 	if err != nil {
-		panic(fmt.Sprintf("Provided Environment variable for: %v can not be cast to int: %s\n", env, value))
+		return 0, fmt.Errorf("Provided Environment variable for: %v can not be cast to int: %s", env, value)
 	}
-	return intVal
+	return intVal, nil
+}
+
+// MustGetenvInt attempts to lookup and return the value associated with the specified environment variable identifier and cast it to an int,
+// panic'ing if no value is associated with that identifier or it cannot be cast
+func MustGetenvInt(env string) int {
+	return mustGetenv(LookupEnvInt(env))
 }
 
 // TransitiveMustGetenvInt will (if a transitive dependnecy such as the value of another environment is set)
 // attempt to lookup the specified environment variable parsed as an int and return its value panicing if not provided
+//
+// Deprecated: use LoadEnv with a requiredIf struct tag instead.
 func TransitiveMustGetenvInt(env string, active bool) int {
 	if !active {
 		return 0
@@ -45,29 +127,46 @@ func TransitiveMustGetenvInt(env string, active bool) int {
 	return MustGetenvInt(env)
 }
 
+// LookupEnvIntNonZero attempts to lookup and return the value associated with the specified environment variable identifier cast to an int,
+// returning an error if no value is associated with that identifier, if it cannot be cast, or if once cast equals zero
+func LookupEnvIntNonZero(env string) (int, error) {
+	value, err := LookupEnvInt(env)
+	if err == nil && value == 0 {
+		err = fmt.Errorf("Provided Environment variable %s: %w", env, ErrEnvZero)
+	}
+	return value, err
+}
+
 // MustGetenvIntNonZero attempts to lookup and return the value associated with the specified environment variable identifier and cast it to an int,
 // panic'ing if no value is associated with that identifier, if it cannot be cast, or if once cast equals zero
 func MustGetenvIntNonZero(env string) int {
-	value := MustGetenvInt(env)
-	if value == 0 {
-		panic(fmt.Sprintf("Provided Environment variable equals 0 when explicitly not allowed: %s\n", env))
+	return mustGetenv(LookupEnvIntNonZero(env))
+}
+
+// LookupEnvFloat attempts to lookup and return the value associated with the specified environment variable identifier cast to a float,
+// returning an error if no value is associated with that identifier or it cannot be cast
+func LookupEnvFloat(env string) (float64, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return 0, err
 	}
-	return value
+	floatVal, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Provided Environment variable for: %v can not be cast to float: %s", env, value)
+	}
+	return floatVal, nil
 }
 
 // MustGetenvFloat attempts to lookup and return the value associated with the specified environment variable identifier and cast it to a float,
 // panic'ing if no value is associated with that identifier or it cannot be cast
 func MustGetenvFloat(env string) float64 {
-	value := MustGetenv(env)
-	floatVal, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		panic(fmt.Sprintf("Provided Environment variable for: %v can not be cast to float: %s\n", env, value))
-	}
-	return floatVal
+	return mustGetenv(LookupEnvFloat(env))
 }
 
 // TransitiveMustGetenvFloat will (if a transitive dependnecy such as the value of another environment is set)
 // attempt to lookup the specified environment variable parsed as a float and return its value panicing if not provided
+//
+// Deprecated: use LoadEnv with a requiredIf struct tag instead.
 func TransitiveMustGetenvFloat(env string, active bool) float64 {
 	if !active {
 		return 0
@@ -75,29 +174,46 @@ func TransitiveMustGetenvFloat(env string, active bool) float64 {
 	return MustGetenvFloat(env)
 }
 
+// LookupEnvFloatNonZero attempts to lookup and return the value associated with the specified environment variable identifier cast to a float,
+// returning an error if no value is associated with that identifier, if it cannot be cast, or if once cast equals zero
+func LookupEnvFloatNonZero(env string) (float64, error) {
+	value, err := LookupEnvFloat(env)
+	if err == nil && value == 0 {
+		err = fmt.Errorf("Provided Environment variable %s: %w", env, ErrEnvZero)
+	}
+	return value, err
+}
+
 // MustGetenvFloatNonZero attempts to lookup and return the value associated with the specified environment variable identifier and cast it to a float,
 // panic'ing if no value is associated with that identifier, if it cannot be cast, or if once cast equals zero
 func MustGetenvFloatNonZero(env string) float64 {
-	value := MustGetenvFloat(env)
-	if value == 0 {
-		panic(fmt.Sprintf("Provided Environment variable equals 0 when explicitly not allowed: %s\n", env))
+	return mustGetenv(LookupEnvFloatNonZero(env))
+}
+
+// LookupEnvBool attempts to lookup and return the value associated with the specified environment variable identifier cast to a bool,
+// returning an error if no value is associated with that identifier or it cannot be cast
+func LookupEnvBool(env string) (bool, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return false, err
 	}
-	return value
+	boolVal, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Provided Environment variable for: %v could not be parsed to a bool: %s", env, value)
+	}
+	return boolVal, nil
 }
 
 // MustGetenvBool attempts to lookup and return the value associated with the specified environment variable identifier and cast it to a bool,
 // panic'ing if no value is associated with that identifier, if it cannot be cast
 func MustGetenvBool(env string) bool {
-	value := MustGetenv(env)
-	boolVal, err := strconv.ParseBool(value)
-	if err != nil {
-		panic(fmt.Sprintf("Provided Environment variable for: %v could not be parsed to a bool: %s\n", env, value))
-	}
-	return boolVal
+	return mustGetenv(LookupEnvBool(env))
 }
 
 // TransitiveMustGetenvBool will (if a transitive dependnecy such as the value of another environment is set)
 // attempt to lookup the specified environment variable parsed as a bool and return its value panicing if not provided
+//
+// Deprecated: use LoadEnv with a requiredIf struct tag instead.
 func TransitiveMustGetenvBool(env string, active bool) bool {
 	if !active {
 		return false
@@ -105,10 +221,180 @@ func TransitiveMustGetenvBool(env string, active bool) bool {
 	return MustGetenvBool(env)
 }
 
-// EnvOrDefault fetches an environment variable value, or if not set returns the fallback value
+// LookupEnvDuration attempts to lookup and return the value associated with the specified environment variable identifier
+// parsed as a duration such as "300ms" or "1h30m", returning an error if no value is associated with that identifier or it cannot be parsed
+func LookupEnvDuration(env string) (time.Duration, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return 0, err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Provided Environment variable for: %v could not be parsed to a duration: %s", env, value)
+	}
+	return duration, nil
+}
+
+// MustGetenvDuration attempts to lookup and return the value associated with the specified environment variable identifier parsed as a duration,
+// panic'ing if no value is associated with that identifier or it cannot be parsed
+func MustGetenvDuration(env string) time.Duration {
+	return mustGetenv(LookupEnvDuration(env))
+}
+
+// LookupEnvList attempts to lookup and return the value associated with the specified environment variable identifier
+// split as a comma separated list (e.g. of Kafka broker endpoints), with whitespace trimmed and empty items removed,
+// returning an error if no value is associated with that identifier
+func LookupEnvList(env string) ([]string, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	return splitList(value), nil
+}
+
+// MustGetenvList attempts to lookup and return the value associated with the specified environment variable identifier split as a comma separated list,
+// panic'ing if no value is associated with that identifier
+func MustGetenvList(env string) []string {
+	return mustGetenv(LookupEnvList(env))
+}
+
+// LookupEnvURL attempts to lookup and return the value associated with the specified environment variable identifier parsed as an absolute URL,
+// returning an error if no value is associated with that identifier or it cannot be parsed
+func LookupEnvURL(env string) (*url.URL, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseURL(value)
+	if err != nil {
+		return nil, fmt.Errorf("Provided Environment variable for: %v could not be parsed to a URL: %s", env, value)
+	}
+	return parsed, nil
+}
+
+// MustGetenvURL attempts to lookup and return the value associated with the specified environment variable identifier parsed as an absolute URL,
+// panic'ing if no value is associated with that identifier or it cannot be parsed
+func MustGetenvURL(env string) *url.URL {
+	return mustGetenv(LookupEnvURL(env))
+}
+
+// LookupEnvByteSize attempts to lookup and return the value associated with the specified environment variable identifier parsed as a ByteSize,
+// returning an error if no value is associated with that identifier or it cannot be parsed
+func LookupEnvByteSize(env string) (ByteSize, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return 0, err
+	}
+	size, err := parseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("Provided Environment variable for: %v could not be parsed to a byte size: %s", env, value)
+	}
+	return size, nil
+}
+
+// MustGetenvByteSize attempts to lookup and return the value associated with the specified environment variable identifier parsed as a ByteSize,
+// panic'ing if no value is associated with that identifier or it cannot be parsed
+func MustGetenvByteSize(env string) ByteSize {
+	return mustGetenv(LookupEnvByteSize(env))
+}
+
+// LookupEnvBase64 attempts to lookup and return the value associated with the specified environment variable identifier decoded from base64
+// (URL or standard alphabet, padded or not), returning an error if no value is associated with that identifier or it cannot be decoded.
+// The decoded value is not included in the error so secret keys are never logged.
+func LookupEnvBase64(env string) ([]byte, error) {
+	value, err := LookupEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeBase64(value)
+	if err != nil {
+		return nil, fmt.Errorf("Provided Environment variable for: %v could not be decoded from base64", env)
+	}
+	return decoded, nil
+}
+
+// MustGetenvBase64 attempts to lookup and return the value associated with the specified environment variable identifier decoded from base64,
+// panic'ing if no value is associated with that identifier or it cannot be decoded
+func MustGetenvBase64(env string) []byte {
+	return mustGetenv(LookupEnvBase64(env))
+}
+
+// LookupEnvOrDefault fetches an environment variable value, or if not set returns the fallback value,
+// returning an error if the value should be read from a file (see EnvFileSuffix) which can not be read
+func LookupEnvOrDefault(key string, fallback string) (string, error) {
+	val, ok, err := lookupEnv(key)
+	if err != nil {
+		return fallback, err
+	}
+	if !ok {
+		return fallback, nil
+	}
+	return val, nil
+}
+
+// EnvOrDefault fetches an environment variable value, or if not set returns the fallback value.
+// If the value should be read from a file which can not be read, the error is logged and the fallback returned.
 func EnvOrDefault(key string, fallback string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
+	val, err := LookupEnvOrDefault(key, fallback)
+	if err != nil {
+		log.Printf("EnvOrDefault: using default for %s: %s\n", key, err)
+	}
+	return val
+}
+
+// mustGetenv panics with err if it is not nil, otherwise returning value.
+func mustGetenv[T any](value T, err error) T {
+	if err != nil {
+		panic(err.Error() + "\n")
+	}
+	return value
+}
+
+// parseURL parses an absolute URL.
+func parseURL(value string) (*url.URL, error) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("URL %q is not absolute", value)
+	}
+	return parsed, nil
+}
+
+// parseByteSize parses a number of bytes with an optional unit suffix.
+func parseByteSize(value string) (ByteSize, error) {
+	trimmed := strings.TrimSpace(value)
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(trimmed)
+	}
+	multiplier, ok := byteSizeUnits[strings.ToUpper(strings.TrimSpace(trimmed[split:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown byte size unit in %q", value)
+	}
+	number, err := strconv.ParseFloat(trimmed[:split], 64)
+	if err != nil {
+		return 0, err
+	}
+	size := number * multiplier
+	// float64(math.MaxInt64) rounds up to 2^63, which would wrap negative when converted
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("byte size %q overflows", value)
+	}
+	return ByteSize(size), nil
+}
+
+// decodeBase64 decodes value using whichever base64 alphabet and padding it was encoded with.
+func decodeBase64(value string) ([]byte, error) {
+	var err error
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding} {
+		var decoded []byte
+		if decoded, err = encoding.DecodeString(value); err == nil {
+			return decoded, nil
+		}
 	}
-	return fallback
+	return nil, err
 }
@@ -0,0 +1,365 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Argon2idAlgorithm is the PHC identifier for argon2id password hashes
+	Argon2idAlgorithm = "argon2id"
+	// ScryptAlgorithm is the PHC identifier for scrypt password hashes
+	ScryptAlgorithm = "scrypt"
+	// maxCalibrationRounds bounds how many times a calibration helper raises cost
+	maxCalibrationRounds = 32
+	// minPasswordSaltLength and maxPasswordSaltLength bound the salt length in bytes
+	minPasswordSaltLength = 8
+	maxPasswordSaltLength = 64
+	// minPasswordKeyLength and maxPasswordKeyLength bound the derived key length in bytes
+	minPasswordKeyLength = 16
+	maxPasswordKeyLength = 64
+	// maxPasswordMemory bounds the memory in bytes a single password hash may use,
+	// so a stored hash can not exhaust memory when it is verified
+	maxPasswordMemory = 1 << 30
+	// maxArgon2idIterations bounds the number of argon2id passes over the memory
+	maxArgon2idIterations = 128
+	// maxPasswordParallelism bounds argon2id threads and scrypt parallelization
+	maxPasswordParallelism = 64
+	// maxScryptR bounds the scrypt block size
+	maxScryptR = 32
+)
+
+var (
+	// ErrInvalidPasswordHash is returned when an encoded password hash can not be parsed
+	ErrInvalidPasswordHash = errors.New("invalid encoded password hash")
+	// ErrUnsupportedPasswordAlgorithm is returned when an encoded password hash uses an unknown algorithm
+	ErrUnsupportedPasswordAlgorithm = errors.New("unsupported password hash algorithm")
+	// ErrInvalidPasswordParams is returned when password hash cost parameters are out of bounds
+	ErrInvalidPasswordParams = errors.New("invalid password hash parameters")
+	// DefaultArgon2idParams are the recommended argon2id parameters for hashing passwords
+	// https://datatracker.ietf.org/doc/html/rfc9106#section-4
+	DefaultArgon2idParams = Argon2idParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	// DefaultScryptParams are the recommended scrypt parameters for hashing passwords
+	DefaultScryptParams = ScryptParams{
+		LogN:       15,
+		R:          8,
+		P:          1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+	// phcEncoding is the base64 encoding used for salts and hashes in PHC strings
+	phcEncoding = base64.RawStdEncoding
+)
+
+// PasswordParams are the algorithm and cost parameters used to hash a password,
+// either Argon2idParams or ScryptParams.
+type PasswordParams interface {
+	// key derives a key of the configured length from password and salt
+	key(password []byte, salt []byte) ([]byte, error)
+	// encode formats salt and key as a PHC string
+	encode(salt []byte, key []byte) string
+	// saltLength is the number of random salt bytes to generate
+	saltLength() int
+	// validate returns an error wrapping ErrInvalidPasswordParams if any parameter is out of bounds
+	validate() error
+}
+
+// validatePasswordLengths returns an error if saltLength or keyLength is out of bounds.
+func validatePasswordLengths(saltLength int, keyLength int) error {
+	if saltLength < minPasswordSaltLength || saltLength > maxPasswordSaltLength {
+		return fmt.Errorf("%w: salt length %d is not between %d and %d", ErrInvalidPasswordParams, saltLength, minPasswordSaltLength, maxPasswordSaltLength)
+	}
+	if keyLength < minPasswordKeyLength || keyLength > maxPasswordKeyLength {
+		return fmt.Errorf("%w: key length %d is not between %d and %d", ErrInvalidPasswordParams, keyLength, minPasswordKeyLength, maxPasswordKeyLength)
+	}
+	return nil
+}
+
+// Argon2idParams are the cost parameters for argon2id password hashes.
+type Argon2idParams struct {
+	Memory      uint32 // Memory to use in KiB
+	Iterations  uint32 // Number of passes over the memory
+	Parallelism uint8  // Number of threads to use
+	SaltLength  uint32 // Length of the random salt in bytes
+	KeyLength   uint32 // Length of the derived key in bytes
+}
+
+func (p Argon2idParams) key(password []byte, salt []byte) ([]byte, error) {
+	return argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength), nil
+}
+
+func (p Argon2idParams) encode(salt []byte, key []byte) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2idAlgorithm, argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism, phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))
+}
+
+func (p Argon2idParams) saltLength() int {
+	return int(p.SaltLength)
+}
+
+func (p Argon2idParams) validate() error {
+	if p.Parallelism < 1 || p.Parallelism > maxPasswordParallelism {
+		return fmt.Errorf("%w: parallelism %d is not between 1 and %d", ErrInvalidPasswordParams, p.Parallelism, maxPasswordParallelism)
+	}
+	if p.Iterations < 1 || p.Iterations > maxArgon2idIterations {
+		return fmt.Errorf("%w: iterations %d is not between 1 and %d", ErrInvalidPasswordParams, p.Iterations, maxArgon2idIterations)
+	}
+	// argon2 requires at least 8 KiB per thread
+	if p.Memory < 8*uint32(p.Parallelism) || uint64(p.Memory)*1024 > maxPasswordMemory {
+		return fmt.Errorf("%w: memory %d KiB is not between %d and %d KiB", ErrInvalidPasswordParams, p.Memory, 8*uint32(p.Parallelism), maxPasswordMemory/1024)
+	}
+	return validatePasswordLengths(int(p.SaltLength), int(p.KeyLength))
+}
+
+// ScryptParams are the cost parameters for scrypt password hashes.
+type ScryptParams struct {
+	LogN       uint8 // Base 2 logarithm of the CPU/memory cost N
+	R          int   // Block size
+	P          int   // Parallelization
+	SaltLength int   // Length of the random salt in bytes
+	KeyLength  int   // Length of the derived key in bytes
+}
+
+func (p ScryptParams) key(password []byte, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, 1<<p.LogN, p.R, p.P, p.KeyLength)
+}
+
+func (p ScryptParams) encode(salt []byte, key []byte) string {
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", ScryptAlgorithm,
+		p.LogN, p.R, p.P, phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))
+}
+
+func (p ScryptParams) saltLength() int {
+	return p.SaltLength
+}
+
+func (p ScryptParams) validate() error {
+	if p.R < 1 || p.R > maxScryptR {
+		return fmt.Errorf("%w: r %d is not between 1 and %d", ErrInvalidPasswordParams, p.R, maxScryptR)
+	}
+	if p.P < 1 || p.P > maxPasswordParallelism {
+		return fmt.Errorf("%w: p %d is not between 1 and %d", ErrInvalidPasswordParams, p.P, maxPasswordParallelism)
+	}
+	// scrypt uses 128*r*N bytes for its main buffer
+	if p.LogN < 1 || p.LogN > 30 || uint64(128*p.R)<<p.LogN > maxPasswordMemory {
+		return fmt.Errorf("%w: ln %d with r %d exceeds %d bytes of memory", ErrInvalidPasswordParams, p.LogN, p.R, maxPasswordMemory)
+	}
+	return validatePasswordLengths(p.SaltLength, p.KeyLength)
+}
+
+// HashPassword hashes password with a random salt using the algorithm and cost
+// parameters described by params, returning a self describing PHC string, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, and error (if any).
+// Returning an error wrapping ErrInvalidPasswordParams if params are out of bounds.
+func HashPassword(password string, params PasswordParams) (string, error) {
+	if err := params.validate(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, params.saltLength())
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := params.key([]byte(password), salt)
+	if err != nil {
+		return "", err
+	}
+	return params.encode(salt, key), nil
+}
+
+// VerifyPassword reports whether password matches the PHC string encodedHash
+// produced by HashPassword, comparing the hashes in constant time.
+// Returning an error if encodedHash can not be parsed or its parameters are out of bounds.
+func VerifyPassword(password string, encodedHash string) (bool, error) {
+	params, salt, key, err := decodePasswordHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	derived, err := params.key([]byte(password), salt)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, derived) == 1, nil
+}
+
+// NeedsRehash reports whether encodedHash was produced with a different algorithm
+// or different cost parameters than params (or a pointer to them), in which case the
+// password should be hashed again with params the next time it is available (e.g. at login).
+func NeedsRehash(encodedHash string, params PasswordParams) (bool, error) {
+	current, _, _, err := decodePasswordHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	switch p := params.(type) {
+	case Argon2idParams:
+		decoded, ok := current.(Argon2idParams)
+		return !ok || decoded != p, nil
+	case *Argon2idParams:
+		if p != nil {
+			return NeedsRehash(encodedHash, *p)
+		}
+	case ScryptParams:
+		decoded, ok := current.(ScryptParams)
+		return !ok || decoded != p, nil
+	case *ScryptParams:
+		if p != nil {
+			return NeedsRehash(encodedHash, *p)
+		}
+	}
+	return false, fmt.Errorf("%w: unsupported parameters %T", ErrInvalidPasswordParams, params)
+}
+
+// CalibrateArgon2id returns a copy of params with Iterations raised until hashing
+// a password takes at least target on this machine, and error (if any).
+// Returning an error wrapping ErrInvalidPasswordParams if params are out of bounds.
+func CalibrateArgon2id(target time.Duration, params Argon2idParams) (Argon2idParams, error) {
+	if params.Iterations == 0 {
+		params.Iterations = 1
+	}
+	if err := params.validate(); err != nil {
+		return params, err
+	}
+	for round := 0; round < maxCalibrationRounds && params.Iterations < maxArgon2idIterations; round++ {
+		elapsed, err := timePasswordHash(params)
+		if err != nil {
+			return params, err
+		}
+		if elapsed >= target {
+			break
+		}
+		params.Iterations++
+	}
+	return params, nil
+}
+
+// CalibrateScrypt returns a copy of params with LogN raised until hashing
+// a password takes at least target on this machine, and error (if any).
+// Returning an error wrapping ErrInvalidPasswordParams if params are out of bounds.
+func CalibrateScrypt(target time.Duration, params ScryptParams) (ScryptParams, error) {
+	if err := params.validate(); err != nil {
+		return params, err
+	}
+	for round := 0; round < maxCalibrationRounds; round++ {
+		elapsed, err := timePasswordHash(params)
+		if err != nil {
+			return params, err
+		}
+		if elapsed >= target {
+			break
+		}
+		next := params
+		next.LogN++
+		if next.validate() != nil {
+			break
+		}
+		params = next
+	}
+	return params, nil
+}
+
+// timePasswordHash measures how long hashing a password with params takes, returning error (if any).
+func timePasswordHash(params PasswordParams) (time.Duration, error) {
+	start := time.Now()
+	if _, err := HashPassword("calibration password", params); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// parsePHCParams strictly parses a PHC parameter segment such as "m=65536,t=3,p=4",
+// which must hold exactly the named 32 bit decimal values in order.
+func parsePHCParams(segment string, names ...string) ([]uint64, bool) {
+	pairs := strings.Split(segment, ",")
+	if len(pairs) != len(names) {
+		return nil, false
+	}
+	values := make([]uint64, len(names))
+	for index, pair := range pairs {
+		value := strings.TrimPrefix(pair, names[index]+"=")
+		if value == pair {
+			return nil, false
+		}
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, false
+		}
+		values[index] = parsed
+	}
+	return values, true
+}
+
+// decodePasswordHash parses a PHC string produced by HashPassword into the
+// parameters, salt and key it describes.
+func decodePasswordHash(encodedHash string) (PasswordParams, []byte, []byte, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+	salt, err := phcEncoding.DecodeString(parts[len(parts)-2])
+	if err != nil {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err := phcEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+	switch parts[1] {
+	case Argon2idAlgorithm:
+		if len(parts) != 6 {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		if version, ok := parsePHCParams(parts[2], "v"); !ok || version[0] != argon2.Version {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		values, ok := parsePHCParams(parts[3], "m", "t", "p")
+		if !ok || values[2] > math.MaxUint8 {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		params := Argon2idParams{
+			Memory:      uint32(values[0]),
+			Iterations:  uint32(values[1]),
+			Parallelism: uint8(values[2]),
+			SaltLength:  uint32(len(salt)),
+			KeyLength:   uint32(len(key)),
+		}
+		if params.validate() != nil {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		return params, salt, key, nil
+	case ScryptAlgorithm:
+		if len(parts) != 5 {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		values, ok := parsePHCParams(parts[2], "ln", "r", "p")
+		if !ok || values[0] > math.MaxUint8 {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		params := ScryptParams{
+			LogN:       uint8(values[0]),
+			R:          int(values[1]),
+			P:          int(values[2]),
+			SaltLength: len(salt),
+			KeyLength:  len(key),
+		}
+		if params.validate() != nil {
+			return nil, nil, nil, ErrInvalidPasswordHash
+		}
+		return params, salt, key, nil
+	}
+	return nil, nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedPasswordAlgorithm, parts[1])
+}
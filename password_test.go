@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Cheap parameters keep the tests fast, they are not suitable for real passwords
+var (
+	testArgon2idParams = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testScryptParams   = ScryptParams{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	for _, params := range []PasswordParams{testArgon2idParams, testScryptParams} {
+		encoded, err := HashPassword("correct horse", params)
+		if err != nil {
+			t.Fatalf("Unexpected error hashing with %+v: %s", params, err)
+		}
+		if ok, err := VerifyPassword("correct horse", encoded); !ok || err != nil {
+			t.Errorf("Expected %s to verify, got %t (error %v)", encoded, ok, err)
+		}
+		if ok, err := VerifyPassword("battery staple", encoded); ok || err != nil {
+			t.Errorf("Expected the wrong password not to verify for %s, got %t (error %v)", encoded, ok, err)
+		}
+		again, err := HashPassword("correct horse", params)
+		if err != nil || again == encoded {
+			t.Errorf("Expected a fresh salt for every hash, got %s twice (error %v)", encoded, err)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	encoded, err := HashPassword("correct horse", testArgon2idParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rehash, err := NeedsRehash(encoded, testArgon2idParams); rehash || err != nil {
+		t.Errorf("Expected no rehash for the same parameters, got %t (error %v)", rehash, err)
+	}
+	stronger := testArgon2idParams
+	stronger.Iterations++
+	if rehash, err := NeedsRehash(encoded, stronger); !rehash || err != nil {
+		t.Errorf("Expected a rehash for stronger parameters, got %t (error %v)", rehash, err)
+	}
+	if rehash, err := NeedsRehash(encoded, testScryptParams); !rehash || err != nil {
+		t.Errorf("Expected a rehash for a different algorithm, got %t (error %v)", rehash, err)
+	}
+	if rehash, err := NeedsRehash(encoded, &testArgon2idParams); rehash || err != nil {
+		t.Errorf("Expected no rehash for a pointer to the same parameters, got %t (error %v)", rehash, err)
+	}
+	if rehash, err := NeedsRehash(encoded, &stronger); !rehash || err != nil {
+		t.Errorf("Expected a rehash for a pointer to stronger parameters, got %t (error %v)", rehash, err)
+	}
+	if rehash, err := NeedsRehash(encoded, &testScryptParams); !rehash || err != nil {
+		t.Errorf("Expected a rehash for a pointer to a different algorithm, got %t (error %v)", rehash, err)
+	}
+}
+
+func TestCalibrateRejectsInvalidParams(t *testing.T) {
+	if _, err := CalibrateArgon2id(time.Second, Argon2idParams{Iterations: 1}); !errors.Is(err, ErrInvalidPasswordParams) {
+		t.Errorf("Expected ErrInvalidPasswordParams calibrating argon2id, got %v", err)
+	}
+	if _, err := CalibrateScrypt(time.Second, ScryptParams{}); !errors.Is(err, ErrInvalidPasswordParams) {
+		t.Errorf("Expected ErrInvalidPasswordParams calibrating scrypt, got %v", err)
+	}
+	params, err := CalibrateArgon2id(0, testArgon2idParams)
+	if err != nil || params != testArgon2idParams {
+		t.Errorf("Expected parameters already meeting the target to be kept, got %+v (error %v)", params, err)
+	}
+}
+
+func TestHashPasswordRejectsInvalidParams(t *testing.T) {
+	for _, params := range []PasswordParams{
+		Argon2idParams{},
+		ScryptParams{},
+		Argon2idParams{Memory: 1 << 30, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		ScryptParams{LogN: 30, R: 8, P: 1, SaltLength: 16, KeyLength: 32},
+	} {
+		if _, err := HashPassword("correct horse", params); !errors.Is(err, ErrInvalidPasswordParams) {
+			t.Errorf("Expected ErrInvalidPasswordParams for %+v, got %v", params, err)
+		}
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedHashes(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for _, encoded := range []string{
+		"",
+		"argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=18$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$",
+		"$scrypt$ln=0,r=8,p=1$" + salt + "$" + key,
+		"$scrypt$ln=40,r=8,p=1$" + salt + "$" + key,
+		"$scrypt$ln=4,r=1000000,p=1$" + salt + "$" + key,
+		"$scrypt$ln=4,r=8,p=0$" + salt + "$" + key,
+		"$argon2id$v=19junk$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1junk$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1,x=2$" + salt + "$" + key,
+		"$argon2id$v=19$t=1,m=64,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=+64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=257$" + salt + "$" + key,
+		"$scrypt$ln=4,r=8,p=1junk$" + salt + "$" + key,
+		"$scrypt$ln=260,r=8,p=1$" + salt + "$" + key,
+		"$scrypt$ln=4,r=8$" + salt + "$" + key,
+	} {
+		if ok, err := VerifyPassword("correct horse", encoded); ok || !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Errorf("Expected ErrInvalidPasswordHash for %q, got %t (error %v)", encoded, ok, err)
+		}
+	}
+	if _, err := VerifyPassword("correct horse", "$bcrypt$v=1$"+salt+"$"+key); !errors.Is(err, ErrUnsupportedPasswordAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedPasswordAlgorithm, got %v", err)
+	}
+}
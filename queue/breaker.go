@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"github.com/tozny/utils-go/breaker"
+)
+
+// breakerQueue decorates a Queue, routing every call through a circuit breaker.
+type breakerQueue struct {
+	queue   Queue
+	breaker *breaker.Breaker
+}
+
+// NewBreakerQueue wraps queue so that calls fail fast with breaker.ErrOpen while
+// the provided circuit breaker is open instead of waiting on a degraded backend.
+func NewBreakerQueue(queue Queue, circuitBreaker *breaker.Breaker) Queue {
+	return &breakerQueue{
+		queue:   queue,
+		breaker: circuitBreaker,
+	}
+}
+
+// DeleteMessage deletes the message with receiptID from the wrapped queue.
+func (q *breakerQueue) DeleteMessage(receiptID string) error {
+	return q.breaker.Execute(func() error {
+		return q.queue.DeleteMessage(receiptID)
+	})
+}
+
+// EnqueueMessage enqueues a single message to the wrapped queue.
+func (q *breakerQueue) EnqueueMessage(message Message) error {
+	return q.breaker.Execute(func() error {
+		return q.queue.EnqueueMessage(message)
+	})
+}
+
+// DequeueMessage dequeues a single message from the wrapped queue.
+func (q *breakerQueue) DequeueMessage() (Message, error) {
+	var message Message
+	err := q.breaker.Execute(func() error {
+		var err error
+		message, err = q.queue.DequeueMessage()
+		return err
+	})
+	return message, err
+}
+
+// BatchEnqueueMessages enqueues a batch of messages to the wrapped queue, returning
+// every message as failed to enqueue if the breaker rejected the call.
+func (q *breakerQueue) BatchEnqueueMessages(messages []Message) ([]Message, error) {
+	failed := messages
+	err := q.breaker.Execute(func() error {
+		var err error
+		failed, err = q.queue.BatchEnqueueMessages(messages)
+		return err
+	})
+	return failed, err
+}
+
+// BatchDequeueMessages dequeues a batch of messages from the wrapped queue.
+func (q *breakerQueue) BatchDequeueMessages() ([]Message, error) {
+	var messages []Message
+	err := q.breaker.Execute(func() error {
+		var err error
+		messages, err = q.queue.BatchDequeueMessages()
+		return err
+	})
+	return messages, err
+}
@@ -3,6 +3,12 @@
 // (e.g. AWS SQS).
 package queue
 
+import (
+	"context"
+
+	"github.com/tozny/utils-go/requestid"
+)
+
 // Message wraps data and metadata for a queue message
 type Message struct {
 	Body         string            // JSON encoded message content
@@ -11,6 +17,27 @@ type Message struct {
 	Tags         map[string]string // Map of user defined key value pairs associated with this message
 }
 
+// WithRequestID returns a copy of message tagged with the request ID carried by ctx
+// (if any) so that consumers can correlate their work with the originating request.
+func WithRequestID(ctx context.Context, message Message) Message {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return message
+	}
+	tags := make(map[string]string, len(message.Tags)+1)
+	for key, value := range message.Tags {
+		tags[key] = value
+	}
+	tags[requestid.Key] = id
+	message.Tags = tags
+	return message
+}
+
+// RequestID returns the request ID the message was tagged with, or "" if there is none.
+func (m Message) RequestID() string {
+	return m.Tags[requestid.Key]
+}
+
 // Queue is the interface which wraps methods for
 // adding and removing message(s), and permanently deleting a message
 // from a queue data structure.
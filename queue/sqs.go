@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -12,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/google/uuid"
 	"github.com/tozny/utils-go/logging"
+	"github.com/tozny/utils-go/retry"
 )
 
 const (
@@ -24,15 +26,16 @@ var (
 
 // SQSQueueConfig wraps configuration for an SQS queue
 type SQSQueueConfig struct {
-	QueueName                string         // The name of the queue to configure
-	SQSEndpoint              string         // Which SQS service endpoint to use for queue interactions
-	SQSRegion                string         // Which AWS region the queue is located in e.g. us-west-2
-	APIKeyID                 string         // AWS API Secret Key ID for IAM user with sqs permissions
-	APIKeySecret             string         // AWS API Secret Key for IAM user with sqs permissions
-	VisibilityTimeoutSeconds int64          // How long a message should be invisible after being dequeued
-	DequeueBatchSize         int64          // Max number of messages that can be dequeued
-	PollSeconds              int64          // How long to poll for dequeueable messages when dequeing messages from the queue
+	QueueName                string         `env:"SQS_QUEUE_NAME,required"`                     // The name of the queue to configure
+	SQSEndpoint              string         `env:"SQS_ENDPOINT,required"`                       // Which SQS service endpoint to use for queue interactions
+	SQSRegion                string         `env:"SQS_REGION,required"`                         // Which AWS region the queue is located in e.g. us-west-2
+	APIKeyID                 string         `env:"AWS_ACCESS_KEY_ID,required"`                  // AWS API Secret Key ID for IAM user with sqs permissions
+	APIKeySecret             string         `env:"AWS_SECRET_ACCESS_KEY,required"`              // AWS API Secret Key for IAM user with sqs permissions
+	VisibilityTimeoutSeconds int64          `env:"SQS_VISIBILITY_TIMEOUT_SECONDS" default:"30"` // How long a message should be invisible after being dequeued
+	DequeueBatchSize         int64          `env:"SQS_DEQUEUE_BATCH_SIZE" default:"10"`         // Max number of messages that can be dequeued
+	PollSeconds              int64          `env:"SQS_POLL_SECONDS" default:"20"`               // How long to poll for dequeueable messages when dequeing messages from the queue
 	Logger                   logging.Logger // Logger to use for queue trace logs
+	Retrier                  *retry.Retrier // Optional retrier for SQS API calls, nil disables retries
 }
 
 // Queue wraps a concrete(AWS SQS) distributed queue for
@@ -45,28 +48,62 @@ type SQSQueue struct {
 	dequeueBatchSize         int64
 	pollSeconds              int64
 	logger                   logging.Logger
+	retrier                  *retry.Retrier
+}
+
+// retry runs the SQS API call made by operation, retrying it with the queue's
+// retrier if one was configured until ctx is done.
+func (q *SQSQueue) retry(ctx context.Context, operation func(ctx context.Context) error) error {
+	if q.retrier == nil {
+		return operation(ctx)
+	}
+	return q.retrier.Do(ctx, operation)
+}
+
+// PingContext checks the queue is reachable by fetching its attributes, returning
+// error (if any). It implements health.Pinger for service checks.
+func (q *SQSQueue) PingContext(ctx context.Context) error {
+	_, err := q.sqsClient.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.url),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	return err
 }
 
 // DeleteMessage deletes the message with messageID from the queue
 // returning error (if any).
 func (q *SQSQueue) DeleteMessage(messageID string) error {
-	_, err := q.sqsClient.DeleteMessage(&sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(q.url),
-		ReceiptHandle: aws.String(messageID),
+	return q.DeleteMessageContext(context.Background(), messageID)
+}
+
+// DeleteMessageContext is DeleteMessage, cancelling the request and any retries when ctx is done.
+func (q *SQSQueue) DeleteMessageContext(ctx context.Context, messageID string) error {
+	return q.retry(ctx, func(ctx context.Context) error {
+		_, err := q.sqsClient.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(q.url),
+			ReceiptHandle: aws.String(messageID),
+		})
+		return err
 	})
-	return err
 }
 
 // EnqueueMessage enqueues a single message to the queue, returning error (if any).
 func (q *SQSQueue) EnqueueMessage(message Message) error {
+	return q.EnqueueMessageContext(context.Background(), message)
+}
+
+// EnqueueMessageContext is EnqueueMessage, cancelling the request and any retries when ctx is done.
+func (q *SQSQueue) EnqueueMessageContext(ctx context.Context, message Message) error {
 	// Construct SendMessageRequest
 	sendMessageRequest := &sqs.SendMessageInput{
 		MessageAttributes: convertTagsToSQSMessageAttributes(message.Tags),
 		MessageBody:       aws.String(message.Body),
 		QueueUrl:          aws.String(q.url),
 	}
-	_, err := q.sqsClient.SendMessage(sendMessageRequest)
-	return err
+	return q.retry(ctx, func(ctx context.Context) error {
+		_, err := q.sqsClient.SendMessageWithContext(ctx, sendMessageRequest)
+		return err
+	})
 }
 
 // BatchEnqueueMessages enqueues a batch of messages to the queue,
@@ -74,6 +111,11 @@ func (q *SQSQueue) EnqueueMessage(message Message) error {
 // BatchEnqueMessages will fail immediately if more
 // than `BatchEnqueueLimit` messages are passed.
 func (q *SQSQueue) BatchEnqueueMessages(messages []Message) ([]Message, error) {
+	return q.BatchEnqueueMessagesContext(context.Background(), messages)
+}
+
+// BatchEnqueueMessagesContext is BatchEnqueueMessages, cancelling the request and any retries when ctx is done.
+func (q *SQSQueue) BatchEnqueueMessagesContext(ctx context.Context, messages []Message) ([]Message, error) {
 	if len(messages) > SQSBatchEnqueueLimit {
 		return messages, BatchSizeExceededError
 	}
@@ -97,7 +139,12 @@ func (q *SQSQueue) BatchEnqueueMessages(messages []Message) ([]Message, error) {
 		Entries:  sqsBatchRequestEntries,
 		QueueUrl: aws.String(q.url),
 	}
-	sendMessageBatchResponse, err := q.sqsClient.SendMessageBatch(sendMessageBatchRequest)
+	var sendMessageBatchResponse *sqs.SendMessageBatchOutput
+	err := q.retry(ctx, func(ctx context.Context) error {
+		var err error
+		sendMessageBatchResponse, err = q.sqsClient.SendMessageBatchWithContext(ctx, sendMessageBatchRequest)
+		return err
+	})
 	if err != nil {
 		q.logger.Printf("BatchEnqueueMessages error %s for batch %+v\n", err, sqsBatchRequestEntries)
 	}
@@ -112,11 +159,16 @@ func (q *SQSQueue) BatchEnqueueMessages(messages []Message) ([]Message, error) {
 // Dequeue dequeues a single messages from the queue,
 // returning dequeued messages and error (if any).
 func (q *SQSQueue) DequeueMessage() (Message, error) {
+	return q.DequeueMessageContext(context.Background())
+}
+
+// DequeueMessageContext is DequeueMessage, cancelling the request and any retries when ctx is done.
+func (q *SQSQueue) DequeueMessageContext(ctx context.Context) (Message, error) {
 	var message Message
 	previousDequeueBatchSize := q.dequeueBatchSize
 	q.dequeueBatchSize = 1
 	defer func() { q.dequeueBatchSize = previousDequeueBatchSize }()
-	messages, err := q.BatchDequeueMessages()
+	messages, err := q.BatchDequeueMessagesContext(ctx)
 	if err != nil {
 		return message, err
 	}
@@ -130,6 +182,11 @@ func (q *SQSQueue) DequeueMessage() (Message, error) {
 // BatchDequeue dequeues ups to `q.DequeueBatchSize` messages from the queue,
 // returning dequeued messages and error (if any).
 func (q *SQSQueue) BatchDequeueMessages() ([]Message, error) {
+	return q.BatchDequeueMessagesContext(context.Background())
+}
+
+// BatchDequeueMessagesContext is BatchDequeueMessages, cancelling the request and any retries when ctx is done.
+func (q *SQSQueue) BatchDequeueMessagesContext(ctx context.Context) ([]Message, error) {
 	var dequeuedMessages []Message
 	//construct ReceiveMessage request
 	receiveMessageRequest := sqs.ReceiveMessageInput{
@@ -147,7 +204,12 @@ func (q *SQSQueue) BatchDequeueMessages() ([]Message, error) {
 	}
 
 	// make ReceiveMessage request
-	receiveMessageResponse, err := q.sqsClient.ReceiveMessage(&receiveMessageRequest)
+	var receiveMessageResponse *sqs.ReceiveMessageOutput
+	err := q.retry(ctx, func(ctx context.Context) error {
+		var err error
+		receiveMessageResponse, err = q.sqsClient.ReceiveMessageWithContext(ctx, &receiveMessageRequest)
+		return err
+	})
 	if err != nil {
 		return dequeuedMessages, err
 	}
@@ -236,6 +298,7 @@ func NewSQSQueue(config SQSQueueConfig) (Queue, error) {
 		dequeueBatchSize:         config.DequeueBatchSize,
 		pollSeconds:              config.PollSeconds,
 		logger:                   config.Logger,
+		retrier:                  config.Retrier,
 	}
 	return sqsQueue, err
 }
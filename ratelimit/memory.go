@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+const (
+	// sweepInterval is the number of calls to Allow between sweeps for idle keys
+	sweepInterval = 1024
+)
+
+// memoryState is the state of the limit for one key.
+type memoryState struct {
+	tokens   float64   // Tokens in the bucket (TokenBucket)
+	window   int64     // Index of the current fixed window (SlidingWindow)
+	previous int       // Actions in the previous fixed window (SlidingWindow)
+	current  int       // Actions in the current fixed window (SlidingWindow)
+	updated  time.Time // When the state was last updated
+}
+
+// MemoryLimiter is a Limiter which keeps state in process memory, for services
+// running a single instance or limiting each instance independently.
+type MemoryLimiter struct {
+	config Config
+	clock  clock.Clock
+	mutex  sync.Mutex
+	states map[string]*memoryState
+	calls  int
+}
+
+// NewMemoryLimiter returns a new in memory Limiter configured with the provided config.
+func NewMemoryLimiter(config Config) (*MemoryLimiter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &MemoryLimiter{
+		config: config,
+		clock:  clock.OrNew(config.Clock),
+		states: map[string]*memoryState{},
+	}, nil
+}
+
+// Allow records an attempt to perform an action for key and reports whether it is allowed.
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	l.sweep(now)
+	state, ok := l.states[key]
+	if !ok {
+		state = &memoryState{tokens: float64(l.config.Limit), window: now.UnixNano() / int64(l.config.Period)}
+		l.states[key] = state
+	}
+	defer func() { state.updated = now }()
+	if l.config.Algorithm == SlidingWindow {
+		return l.allowSlidingWindow(state, now), nil
+	}
+	return l.allowTokenBucket(state, now), nil
+}
+
+// allowTokenBucket refills the bucket for the time since it was last updated and takes a token if one is available.
+func (l *MemoryLimiter) allowTokenBucket(state *memoryState, now time.Time) Result {
+	if !state.updated.IsZero() {
+		refill := float64(now.Sub(state.updated)) / float64(l.config.Period) * float64(l.config.Limit)
+		state.tokens = math.Min(float64(l.config.Limit), state.tokens+refill)
+	}
+	allowed := state.tokens >= 1
+	if allowed {
+		state.tokens--
+	}
+	return tokenBucketResult(l.config, allowed, state.tokens)
+}
+
+// allowSlidingWindow advances the fixed windows to now and counts the action if the estimate allows it.
+func (l *MemoryLimiter) allowSlidingWindow(state *memoryState, now time.Time) Result {
+	period := int64(l.config.Period)
+	window := now.UnixNano() / period
+	if window != state.window {
+		if window == state.window+1 {
+			state.previous = state.current
+		} else {
+			state.previous = 0
+		}
+		state.current = 0
+		state.window = window
+	}
+	elapsed := time.Duration(now.UnixNano() - window*period)
+	allowed := slidingWindowEstimate(l.config, state.previous, state.current, elapsed)+1 <= float64(l.config.Limit)
+	if allowed {
+		state.current++
+	}
+	return slidingWindowResult(l.config, allowed, state.previous, state.current, elapsed)
+}
+
+// sweep periodically removes keys whose limits have been fully replenished so that
+// memory use is bounded by the number of recently active keys.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	l.calls++
+	if l.calls < sweepInterval {
+		return
+	}
+	l.calls = 0
+	for key, state := range l.states {
+		if now.Sub(state.updated) > 2*l.config.Period {
+			delete(l.states, key)
+		}
+	}
+}
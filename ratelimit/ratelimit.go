@@ -0,0 +1,110 @@
+// Package ratelimit provides rate limiters which bound how often each key (e.g. a
+// client ID or IP address) may perform an action, using either a token bucket or a
+// sliding window, backed by process memory for a single instance or Redis for a fleet.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+var (
+	// ErrInvalidConfig is returned when a limiter is configured without a positive limit and period
+	ErrInvalidConfig = errors.New("rate limit requires a positive limit and period")
+)
+
+// Algorithm is the algorithm a limiter uses to decide whether to allow an action.
+type Algorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit actions, refilling at Limit actions per Period
+	TokenBucket Algorithm = iota
+	// SlidingWindow allows up to Limit actions in any window of length Period,
+	// approximated by weighting the count of the previous fixed window
+	SlidingWindow
+)
+
+// Config wraps configuration for a rate limiter.
+type Config struct {
+	Algorithm Algorithm     // Algorithm used to decide whether to allow an action
+	Limit     int           // Number of actions allowed per Period
+	Period    time.Duration // Length of the window, or time to refill an empty token bucket
+	Prefix    string        // Prefix for keys stored in Redis, ignored by in memory limiters
+	Clock     clock.Clock   // Source of the current time, nil uses the system clock
+}
+
+// validate checks config describes a usable limit.
+func (c Config) validate() error {
+	if c.Limit <= 0 || c.Period <= 0 {
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// Result reports the outcome of asking a limiter to allow an action.
+type Result struct {
+	Allowed    bool          // Whether the action is allowed
+	Limit      int           // Number of actions allowed per period
+	Remaining  int           // Number of further actions allowed right now
+	ResetAfter time.Duration // Time until the limit is fully replenished
+	RetryAfter time.Duration // Time until an action will next be allowed, zero if allowed
+}
+
+// Limiter is the interface which wraps the Allow method, which records an attempt
+// to perform an action for key and reports whether it is allowed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// tokenBucketResult returns the result for a token bucket holding tokens after the
+// current action was allowed (and its token taken) or denied.
+func tokenBucketResult(config Config, allowed bool, tokens float64) Result {
+	refillPerToken := float64(config.Period) / float64(config.Limit)
+	result := Result{
+		Allowed:    allowed,
+		Limit:      config.Limit,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(config.Limit) - tokens) * refillPerToken),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * refillPerToken)
+	}
+	return result
+}
+
+// slidingWindowEstimate returns the estimated number of actions in the sliding window
+// ending elapsed into the current fixed window.
+func slidingWindowEstimate(config Config, previous int, current int, elapsed time.Duration) float64 {
+	weight := 1 - float64(elapsed)/float64(config.Period)
+	return float64(previous)*weight + float64(current)
+}
+
+// slidingWindowResult returns the result for a sliding window whose previous and current
+// fixed windows hold the provided counts, including the current action if it was allowed.
+func slidingWindowResult(config Config, allowed bool, previous int, current int, elapsed time.Duration) Result {
+	estimate := slidingWindowEstimate(config, previous, current, elapsed)
+	result := Result{
+		Allowed:    allowed,
+		Limit:      config.Limit,
+		Remaining:  int(math.Max(0, math.Floor(float64(config.Limit)-estimate))),
+		ResetAfter: 2*config.Period - elapsed,
+	}
+	if current == 0 {
+		result.ResetAfter = config.Period - elapsed
+	}
+	if !allowed {
+		// Wait until enough of the previous window has slid out to make room for one more action
+		result.RetryAfter = config.Period - elapsed
+		if room := float64(config.Limit - current - 1); room >= 0 && previous > 0 {
+			result.RetryAfter = time.Duration((1-room/float64(previous))*float64(config.Period)) - elapsed
+		}
+		if result.RetryAfter < 0 {
+			result.RetryAfter = 0
+		}
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+func TestTokenBucketAllowsBurstThenRefills(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	limiter, err := NewMemoryLimiter(Config{Algorithm: TokenBucket, Limit: 2, Period: 2 * time.Second, Clock: fakeClock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if result, _ := limiter.Allow(ctx, "client"); !result.Allowed || result.Remaining != 1-i {
+			t.Errorf("Expected burst request %d to be allowed, got %+v", i, result)
+		}
+	}
+	result, _ := limiter.Allow(ctx, "client")
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("Expected request over the limit to be denied for 1s, got %+v", result)
+	}
+	if other, _ := limiter.Allow(ctx, "other"); !other.Allowed {
+		t.Errorf("Expected keys to be limited independently")
+	}
+	fakeClock.Advance(time.Second)
+	if result, _ := limiter.Allow(ctx, "client"); !result.Allowed {
+		t.Errorf("Expected request to be allowed after a token refilled, got %+v", result)
+	}
+}
+
+func TestSlidingWindowWeighsPreviousWindow(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	limiter, err := NewMemoryLimiter(Config{Algorithm: SlidingWindow, Limit: 4, Period: 10 * time.Second, Clock: fakeClock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		limiter.Allow(ctx, "client")
+	}
+	if result, _ := limiter.Allow(ctx, "client"); result.Allowed || result.RetryAfter != 10*time.Second {
+		t.Errorf("Expected fifth request in the window to be denied until the window ends, got %+v", result)
+	}
+	// Halfway through the next window the previous window counts for 2 of the 4 allowed
+	fakeClock.Advance(15 * time.Second)
+	for i := 0; i < 2; i++ {
+		if result, _ := limiter.Allow(ctx, "client"); !result.Allowed {
+			t.Errorf("Expected request %d to be allowed, got %+v", i, result)
+		}
+	}
+	if result, _ := limiter.Allow(ctx, "client"); result.Allowed {
+		t.Errorf("Expected request over the weighted limit to be denied, got %+v", result)
+	}
+	if _, err := NewMemoryLimiter(Config{}); err != ErrInvalidConfig {
+		t.Errorf("Expected ErrInvalidConfig for an empty config, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tozny/utils-go/clock"
+)
+
+var (
+	// tokenBucketScript refills and takes a token from the bucket stored in the hash KEYS[1].
+	// ARGV: limit, period in milliseconds, current time in milliseconds.
+	// Returns whether the action was allowed and the tokens remaining as a string.
+	tokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil then
+	tokens = limit
+elseif now > updated then
+	tokens = math.min(limit, tokens + (now - updated) / period * limit)
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", math.max(now, updated or now))
+redis.call("PEXPIRE", KEYS[1], period * 2)
+return {allowed, tostring(tokens)}
+`)
+	// slidingWindowScript counts an action in the fixed window KEYS[2] if the weighted
+	// count of the previous fixed window KEYS[1] and the current window allows it.
+	// ARGV: limit, period in milliseconds, milliseconds elapsed in the current window.
+	// Returns whether the action was allowed and the previous and current window counts.
+	slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local previous = tonumber(redis.call("GET", KEYS[1]) or "0")
+local current = tonumber(redis.call("GET", KEYS[2]) or "0")
+local allowed = 0
+if previous * (1 - elapsed / period) + current + 1 <= limit then
+	current = redis.call("INCR", KEYS[2])
+	redis.call("PEXPIRE", KEYS[2], period * 2)
+	allowed = 1
+end
+return {allowed, previous, current}
+`)
+)
+
+// RedisLimiter is a Limiter which keeps state in Redis, so that every instance of
+// a service shares the same limits. Use a client created with cache.NewClient.
+type RedisLimiter struct {
+	config Config
+	clock  clock.Clock
+	client redis.Cmdable
+}
+
+// NewRedisLimiter returns a new Limiter storing state with client, configured with the provided config.
+func NewRedisLimiter(client redis.Cmdable, config Config) (*RedisLimiter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Prefix == "" {
+		config.Prefix = "ratelimit"
+	}
+	return &RedisLimiter{
+		config: config,
+		clock:  clock.OrNew(config.Clock),
+		client: client,
+	}, nil
+}
+
+// Allow records an attempt to perform an action for key and reports whether it is allowed.
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := l.clock.Now()
+	period := l.config.Period.Milliseconds()
+	if period == 0 {
+		period = 1
+	}
+	// The hash tag keeps all of a key's windows in the same cluster slot
+	base := fmt.Sprintf("%s:{%s}", l.config.Prefix, key)
+	if l.config.Algorithm == SlidingWindow {
+		window := now.UnixMilli() / period
+		elapsed := now.UnixMilli() - window*period
+		values, err := slidingWindowScript.Run(ctx, l.client,
+			[]string{fmt.Sprintf("%s:%d", base, window-1), fmt.Sprintf("%s:%d", base, window)},
+			l.config.Limit, period, elapsed).Int64Slice()
+		if err != nil {
+			return Result{}, err
+		}
+		if len(values) != 3 {
+			return Result{}, fmt.Errorf("unexpected sliding window script result %v", values)
+		}
+		return slidingWindowResult(l.config, values[0] == 1, int(values[1]), int(values[2]), time.Duration(elapsed)*time.Millisecond), nil
+	}
+	values, err := tokenBucketScript.Run(ctx, l.client, []string{base}, l.config.Limit, period, now.UnixMilli()).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected token bucket script result %v", values)
+	}
+	allowed, _ := values[0].(int64)
+	encodedTokens, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(encodedTokens, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return tokenBucketResult(l.config, allowed == 1, tokens), nil
+}
@@ -0,0 +1,69 @@
+// Package requestid provides a request (correlation) ID which is accepted from or
+// generated for each inbound request, carried through the request context, and
+// attached to log lines, responses and outbound queue messages and stream events
+// so that work done on behalf of a single request can be traced across services.
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Header is the HTTP header request IDs are read from and written to
+	Header = "X-Request-ID"
+	// Key is the key request IDs are stored under in logs, queue message tags and stream event headers
+	Key = "request-id"
+	// MaxLength is the longest request ID accepted from a caller
+	MaxLength = 128
+)
+
+// contextKey is the type of the context key request IDs are stored under.
+type contextKey struct{}
+
+// New generates a new random request ID.
+func New() string {
+	return uuid.New().String()
+}
+
+// Valid reports whether id is acceptable as a request ID supplied by a caller:
+// non empty, at most MaxLength characters and only printable ASCII without spaces.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromRequest returns the request ID carried by r's context, falling back to a
+// valid ID in r's request ID header, or "" if there is neither.
+func FromRequest(r *http.Request) string {
+	if id := FromContext(r.Context()); id != "" {
+		return id
+	}
+	if id := r.Header.Get(Header); Valid(id) {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package requestid
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFromRequestPrefersContextAndValidatesHeader(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(Header, "caller-id")
+	if id := FromRequest(r); id != "caller-id" {
+		t.Errorf("Expected header request ID, got %q", id)
+	}
+	r = r.WithContext(NewContext(context.Background(), "context-id"))
+	if id := FromRequest(r); id != "context-id" {
+		t.Errorf("Expected context request ID, got %q", id)
+	}
+	for _, invalid := range []string{"", "has space", "line\nbreak", strings.Repeat("a", MaxLength+1)} {
+		r = httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(Header, invalid)
+		if id := FromRequest(r); id != "" {
+			t.Errorf("Expected invalid header %q to be ignored, got %q", invalid, id)
+		}
+	}
+	if !Valid(New()) {
+		t.Errorf("Expected generated request ID to be valid")
+	}
+}
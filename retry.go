@@ -1,49 +1,177 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"math/rand"
 	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+var (
+	// ErrNotReady is reported as the last error when a Ready function used
+	// through Await or AwaitInterval reports it is not ready.
+	ErrNotReady = errors.New("not ready")
 )
 
 // Ready is a type of function that reports
 // readiness of some state or action, returning
-// bool for readiness and error (if any).
+// bool for readiness.
 type Ready func() bool
 
-// Await waits until the ready function is ready
-// or errors, returning success and error (if any).
-// To stop waiting, send on the stop channel.z
-// It checks if the function is ready once and then retries
-// the specified number of times with an exponential backoff between each attempt
-func Await(ready Ready, maxRetries int) bool {
-	for tries := 0; tries <= maxRetries; tries++ {
-		success := ready()
-		if !success {
-			if tries != maxRetries {
-				// exponentially back off before the next attempt
-				// https://github.com/adonovan/gopl.io/blob/77e9f810f3c2502e9c641b97e09f9721424090f5/ch5/wait/wait.go#L30
-				time.Sleep((1 * time.Second) << uint(tries))
+// ReadyErr is a type of function that reports readiness of some state or
+// action, returning nil when ready or an error describing why it is not.
+type ReadyErr func() error
+
+// Policy configures how AwaitContext spaces out readiness checks.
+type Policy struct {
+	BaseDelay   time.Duration // Delay before the first retry
+	MaxDelay    time.Duration // Upper bound for any single delay, zero for no bound
+	Multiplier  float64       // Factor the delay grows by after each retry, zero defaults to 2
+	Jitter      float64       // Fraction (0 to 1) of each delay that is randomized
+	MaxAttempts int           // Maximum number of checks to make, zero for no limit
+	Deadline    time.Duration // Maximum total time to wait, zero for no limit
+	Clock       clock.Clock   // Source of time for delays and the deadline, nil uses the system clock
+}
+
+// AwaitOption configures the waiting behavior of Await and AwaitInterval.
+type AwaitOption func(*Policy)
+
+// WithClock sets the clock used to measure delays and timeouts while waiting.
+func WithClock(c clock.Clock) AwaitOption {
+	return func(p *Policy) {
+		p.Clock = c
+	}
+}
+
+// AwaitResult reports the outcome of waiting on a ReadyErr function.
+type AwaitResult struct {
+	Ready    bool  // Whether the check reported ready
+	Attempts int   // Number of times the check was run
+	LastErr  error // The last error returned by the check, nil if ready
+}
+
+// AwaitContext waits until the ready function returns nil, the policy runs out
+// of attempts or time, or ctx is done. The ready function is checked once and
+// then retried with a backoff between each attempt as described by policy.
+// The returned error is nil when ready, ctx.Err() if waiting was cut short by
+// the context or deadline, otherwise the last error returned by ready.
+func AwaitContext(ctx context.Context, ready ReadyErr, policy Policy) (AwaitResult, error) {
+	var result AwaitResult
+	timeSource := clock.OrNew(policy.Clock)
+	var deadline time.Time
+	if policy.Deadline > 0 {
+		deadline = timeSource.Now().Add(policy.Deadline)
+	}
+	delay := policy.BaseDelay
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		result.Attempts++
+		result.LastErr = ready()
+		if result.LastErr == nil {
+			result.Ready = true
+			return result, nil
+		}
+		if policy.MaxAttempts > 0 && result.Attempts >= policy.MaxAttempts {
+			return result, result.LastErr
+		}
+		wait := policy.jitter(delay)
+		expires := false
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(timeSource.Now())
+			if remaining <= wait {
+				wait, expires = remaining, true
 			}
-			continue
 		}
-		return true
-	}
-	return false
-}
-
-// AwaitInterval waits until the ready function is ready or errors, returning
-// success and error (if any). It checks if the function is ready once, then
-// waits the specified time interval (in seconds) and retries. If the specified
-// timeout is past (taken in seconds) it will return false.
-func AwaitInterval(ready Ready, interval int, timeout int) bool {
-	timeoutTime := time.Now().Add(time.Duration(timeout) * time.Second)
-	intervalTime := time.Duration(interval) * time.Second
-	for timeoutTime.After(time.Now()) {
-		success := ready()
-		if !success {
-			time.Sleep(intervalTime)
-			continue
+		timer := timeSource.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, ctx.Err()
+		case <-timer.C():
+		}
+		if expires {
+			return result, context.DeadlineExceeded
+		}
+		delay = policy.next(delay)
+	}
+}
+
+// next returns the delay to use after the provided one.
+func (p Policy) next(delay time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	next := time.Duration(float64(delay) * multiplier)
+	if p.MaxDelay > 0 && (next > p.MaxDelay || next < 0) {
+		next = p.MaxDelay
+	}
+	return next
+}
+
+// jitter randomly shortens delay by up to the policy's jitter fraction.
+func (p Policy) jitter(delay time.Duration) time.Duration {
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	return delay - time.Duration(rand.Float64()*jitter*float64(delay))
+}
+
+// readyErr adapts a Ready function to a ReadyErr function.
+func (ready Ready) readyErr() ReadyErr {
+	return func() error {
+		if !ready() {
+			return ErrNotReady
 		}
-		return true
+		return nil
+	}
+}
+
+// Await waits until the ready function is ready, returning success.
+// It checks if the function is ready once and then retries
+// the specified number of times with an exponential backoff between each attempt
+func Await(ready Ready, maxRetries int, options ...AwaitOption) bool {
+	if maxRetries < 0 {
+		return false
+	}
+	policy := Policy{
+		BaseDelay:   1 * time.Second,
+		MaxAttempts: maxRetries + 1,
+	}
+	for _, option := range options {
+		option(&policy)
+	}
+	result, _ := AwaitContext(context.Background(), ready.readyErr(), policy)
+	return result.Ready
+}
+
+// AwaitInterval waits until the ready function is ready, returning success.
+// It checks if the function is ready once, then waits the specified time
+// interval (in seconds) and retries. If the specified timeout is past (taken
+// in seconds) it will return false.
+func AwaitInterval(ready Ready, interval int, timeout int, options ...AwaitOption) bool {
+	if timeout <= 0 {
+		return false
+	}
+	policy := Policy{
+		BaseDelay:  time.Duration(interval) * time.Second,
+		Multiplier: 1,
+		Deadline:   time.Duration(timeout) * time.Second,
+	}
+	for _, option := range options {
+		option(&policy)
 	}
-	return false
+	result, _ := AwaitContext(context.Background(), ready.readyErr(), policy)
+	return result.Ready
 }
@@ -0,0 +1,79 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-pg/pg/v10"
+)
+
+// Classifier reports whether an error is worth retrying.
+type Classifier func(err error) bool
+
+// Any returns a Classifier reporting an error as retryable if any of the
+// provided classifiers do.
+func Any(classifiers ...Classifier) Classifier {
+	return func(err error) bool {
+		for _, classifier := range classifiers {
+			if classifier(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AWSThrottling reports whether err is an AWS API throttling error or an error
+// the AWS SDK considers transient.
+func AWSThrottling(err error) bool {
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
+
+// postgresRetryableCodes are the SQLSTATE codes for failures that can succeed
+// when the transaction is retried.
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var postgresRetryableCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+// PostgresSerializationFailure reports whether err is a Postgres serialization
+// failure or deadlock, both of which can succeed if the transaction is retried.
+func PostgresSerializationFailure(err error) bool {
+	var pgErr pg.Error
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return postgresRetryableCodes[pgErr.Field('C')]
+}
+
+// KafkaLeaderNotAvailable reports whether err is a Kafka error raised while
+// partition leadership is moving between brokers.
+func KafkaLeaderNotAvailable(err error) bool {
+	return errors.Is(err, sarama.ErrLeaderNotAvailable) ||
+		errors.Is(err, sarama.ErrNotLeaderForPartition)
+}
+
+// StatusError is returned for HTTP responses with an unsuccessful status code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// RetryableStatus reports whether an HTTP status code indicates a request may
+// succeed if retried (429 Too Many Requests or any 5xx status).
+func RetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// HTTPStatus reports whether err is a StatusError with a retryable status code.
+func HTTPStatus(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && RetryableStatus(statusErr.StatusCode)
+}
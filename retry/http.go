@@ -0,0 +1,71 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper which retries requests that fail or
+// receive a retryable status code (see RetryableStatus) using a Retrier.
+// Requests with a body are only retried if the body can be replayed via GetBody.
+type Transport struct {
+	Base    http.RoundTripper // Transport to make each attempt with, nil uses http.DefaultTransport
+	Retrier Retrier
+}
+
+// NewTransport returns a Transport retrying requests made through base
+// according to retrier.
+func NewTransport(base http.RoundTripper, retrier Retrier) *Transport {
+	return &Transport{
+		Base:    base,
+		Retrier: retrier,
+	}
+}
+
+// RoundTrip executes a single HTTP transaction, retrying it as needed and
+// returning the response from the final attempt.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return base.RoundTrip(req)
+	}
+	var response *http.Response
+	attempt := 0
+	err := t.Retrier.Do(req.Context(), func(ctx context.Context) error {
+		attempt++
+		if response != nil {
+			// Drain and close the rejected response so the connection can be reused
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+			response = nil
+		}
+		attemptRequest := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return Permanent(err)
+			}
+			attemptRequest = req.Clone(ctx)
+			attemptRequest.Body = body
+		}
+		var err error
+		response, err = base.RoundTrip(attemptRequest)
+		if err != nil {
+			return err
+		}
+		if RetryableStatus(response.StatusCode) {
+			return &StatusError{StatusCode: response.StatusCode}
+		}
+		return nil
+	})
+	if response != nil {
+		// The final attempt received a response, even if its status was retryable
+		return response, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,187 @@
+// Package retry provides composable retry policies, retryable error classifiers
+// and a Retrier for re-running operations against unreliable dependencies
+// (e.g. AWS SQS, Postgres, Kafka or HTTP services).
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+	"github.com/tozny/utils-go/logging"
+)
+
+// Policy decides how long to wait before retrying an operation.
+type Policy interface {
+	// Next returns the delay to wait before the next attempt given the number of
+	// attempts made so far and the previous delay (zero before the first retry),
+	// and false if no further attempts should be made.
+	Next(attempt int, previous time.Duration) (time.Duration, bool)
+}
+
+// PolicyFunc is an adapter to allow the use of ordinary functions as a Policy.
+type PolicyFunc func(attempt int, previous time.Duration) (time.Duration, bool)
+
+// Next calls f(attempt, previous).
+func (f PolicyFunc) Next(attempt int, previous time.Duration) (time.Duration, bool) {
+	return f(attempt, previous)
+}
+
+// Constant returns a Policy which always waits delay between attempts.
+func Constant(delay time.Duration) Policy {
+	return PolicyFunc(func(int, time.Duration) (time.Duration, bool) {
+		return delay, true
+	})
+}
+
+// Exponential returns a Policy which doubles the delay after each attempt
+// starting from base, never waiting longer than max (if max is non zero).
+func Exponential(base time.Duration, max time.Duration) Policy {
+	return PolicyFunc(func(attempt int, _ time.Duration) (time.Duration, bool) {
+		delay := base
+		for i := 1; i < attempt && delay < math.MaxInt64/2; i++ {
+			if max > 0 && delay >= max {
+				break
+			}
+			delay *= 2
+		}
+		if max > 0 && delay > max {
+			delay = max
+		}
+		return delay, true
+	})
+}
+
+// DecorrelatedJitter returns a Policy which picks a random delay between base
+// and three times the previous delay, never waiting longer than max.
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func DecorrelatedJitter(base time.Duration, max time.Duration) Policy {
+	return PolicyFunc(func(_ int, previous time.Duration) (time.Duration, bool) {
+		if previous < base {
+			previous = base
+		}
+		// Clamp before multiplying so large or unbounded delays can not overflow
+		if max > 0 && previous > max {
+			previous = max
+		}
+		if previous > math.MaxInt64/3 {
+			previous = math.MaxInt64 / 3
+		}
+		upper := previous * 3
+		delay := base
+		if upper > base {
+			delay += time.Duration(rand.Int63n(int64(upper - base)))
+		}
+		if max > 0 && delay > max {
+			delay = max
+		}
+		return delay, true
+	})
+}
+
+// WithMaxAttempts decorates a Policy so that no more than maxAttempts
+// attempts (including the first) are made.
+func WithMaxAttempts(policy Policy, maxAttempts int) Policy {
+	return PolicyFunc(func(attempt int, previous time.Duration) (time.Duration, bool) {
+		if attempt >= maxAttempts {
+			return 0, false
+		}
+		return policy.Next(attempt, previous)
+	})
+}
+
+// WithJitter decorates a Policy so that each delay is randomly shortened by
+// up to fraction (0 to 1) of its value.
+func WithJitter(policy Policy, fraction float64) Policy {
+	return PolicyFunc(func(attempt int, previous time.Duration) (time.Duration, bool) {
+		delay, ok := policy.Next(attempt, previous)
+		if !ok || delay <= 0 || fraction <= 0 {
+			return delay, ok
+		}
+		if fraction > 1 {
+			fraction = 1
+		}
+		return delay - time.Duration(rand.Float64()*fraction*float64(delay)), true
+	})
+}
+
+// Hook is called after a failed attempt that will be retried, with the number
+// of attempts made so far, the error from the attempt and the delay before the
+// next attempt.
+type Hook func(attempt int, err error, delay time.Duration)
+
+// LogHook returns a Hook which logs each retried failure of operation.
+func LogHook(logger logging.Logger, operation string) Hook {
+	return func(attempt int, err error, delay time.Duration) {
+		logger.Errorf("%s: attempt %d failed with error %s, retrying in %s", operation, attempt, err, delay)
+	}
+}
+
+// permanentError marks an error as not retryable.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so that a Retrier stops retrying and returns err
+// regardless of how the Retrier classifies it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Retrier re-runs operations which fail with retryable errors according to a Policy.
+type Retrier struct {
+	Policy    Policy      // Policy for spacing out attempts, nil makes a single attempt
+	Retryable Classifier  // Reports which errors to retry, nil retries all errors
+	OnRetry   []Hook      // Hooks called before waiting to retry a failed attempt
+	Clock     clock.Clock // Source of time for delays between attempts, nil uses the system clock
+}
+
+// Do runs operation until it succeeds, fails with an error which is not retryable,
+// the policy stops retrying or ctx is done, returning the error from the last
+// attempt or ctx.Err() if ctx was done while waiting to retry.
+func (r Retrier) Do(ctx context.Context, operation func(ctx context.Context) error) error {
+	var delay time.Duration
+	timeSource := clock.OrNew(r.Clock)
+	for attempt := 1; ; attempt++ {
+		err := operation(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if r.Policy == nil || (r.Retryable != nil && !r.Retryable(err)) {
+			return err
+		}
+		var ok bool
+		delay, ok = r.Policy.Next(attempt, delay)
+		if !ok {
+			return err
+		}
+		for _, hook := range r.OnRetry {
+			hook(attempt, err, delay)
+		}
+		timer := timeSource.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
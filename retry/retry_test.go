@@ -0,0 +1,99 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetrierStopsAtMaxAttempts(t *testing.T) {
+	attempts := 0
+	hooked := 0
+	retrier := Retrier{
+		Policy:  WithMaxAttempts(Constant(time.Millisecond), 3),
+		OnRetry: []Hook{func(int, error, time.Duration) { hooked++ }},
+	}
+	err := retrier.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient {
+		t.Errorf("Expected last attempt error, got %v", err)
+	}
+	if attempts != 3 || hooked != 2 {
+		t.Errorf("Expected 3 attempts and 2 hook calls, got %d and %d", attempts, hooked)
+	}
+}
+
+func TestRetrierSkipsNonRetryableAndPermanentErrors(t *testing.T) {
+	errFatal := errors.New("fatal")
+	retrier := Retrier{
+		Policy:    Constant(time.Millisecond),
+		Retryable: func(err error) bool { return err == errTransient },
+	}
+	for _, returned := range []error{errFatal, Permanent(errTransient)} {
+		attempts := 0
+		err := retrier.Do(context.Background(), func(context.Context) error {
+			attempts++
+			return returned
+		})
+		if attempts != 1 || !errors.Is(err, returned) && !errors.Is(returned, err) {
+			t.Errorf("Expected a single attempt returning %v, got %d attempts and %v", returned, attempts, err)
+		}
+	}
+}
+
+func TestExponentialAndDecorrelatedJitterRespectMax(t *testing.T) {
+	exponential := Exponential(time.Second, 5*time.Second)
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay, _ := exponential.Next(attempt+1, 0); delay != expected {
+			t.Errorf("Attempt %d: expected delay %s, got %s", attempt+1, expected, delay)
+		}
+	}
+	jitter := DecorrelatedJitter(time.Second, 5*time.Second)
+	var delay time.Duration
+	for attempt := 1; attempt < 20; attempt++ {
+		delay, _ = jitter.Next(attempt, delay)
+		if delay < time.Second || delay > 5*time.Second {
+			t.Fatalf("Decorrelated jitter delay %s out of bounds", delay)
+		}
+	}
+	unbounded := DecorrelatedJitter(time.Second, 0)
+	for _, previous := range []time.Duration{math.MaxInt64 / 3, math.MaxInt64/3 + 1, math.MaxInt64} {
+		if delay, _ := unbounded.Next(1, previous); delay < time.Hour {
+			t.Errorf("Expected unbounded decorrelated jitter not to overflow after %s, got %s", previous, delay)
+		}
+	}
+}
+
+func TestTransportRetriesRetryableStatus(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewTransport(nil, Retrier{
+		Policy:    WithMaxAttempts(Constant(time.Millisecond), 5),
+		Retryable: HTTPStatus,
+	})}
+	response, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || requests != 3 {
+		t.Errorf("Expected success after 3 requests, got %d after %d", response.StatusCode, requests)
+	}
+}
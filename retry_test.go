@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+)
+
+func TestAwaitContextReportsAttemptsAndLastError(t *testing.T) {
+	errDown := errors.New("dependency down")
+	calls := 0
+	result, err := AwaitContext(context.Background(), func() error {
+		calls++
+		return errDown
+	}, Policy{BaseDelay: time.Millisecond, MaxAttempts: 3})
+	if !errors.Is(err, errDown) {
+		t.Errorf("Expected last check error, got %v", err)
+	}
+	if result.Ready || result.Attempts != 3 || calls != 3 || result.LastErr != errDown {
+		t.Errorf("Unexpected await result %+v after %d calls", result, calls)
+	}
+}
+
+func TestAwaitContextStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := AwaitContext(ctx, func() error {
+		cancel()
+		return ErrNotReady
+	}, Policy{BaseDelay: time.Hour})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context cancellation, got %v", err)
+	}
+	if result.Attempts != 1 || result.LastErr != ErrNotReady {
+		t.Errorf("Unexpected await result %+v", result)
+	}
+}
+
+func TestAwaitContextRespectsDeadline(t *testing.T) {
+	start := time.Now()
+	_, err := AwaitContext(context.Background(), func() error {
+		return ErrNotReady
+	}, Policy{BaseDelay: time.Hour, Deadline: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Deadline was not enforced while sleeping")
+	}
+}
+
+func TestAwaitContextSucceeds(t *testing.T) {
+	calls := 0
+	result, err := AwaitContext(context.Background(), func() error {
+		calls++
+		if calls < 2 {
+			return ErrNotReady
+		}
+		return nil
+	}, Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Jitter: 0.5})
+	if err != nil || !result.Ready || result.Attempts != 2 || result.LastErr != nil {
+		t.Errorf("Unexpected await result %+v error %v", result, err)
+	}
+}
+
+func TestAwaitIntervalWithFakeClock(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	done := make(chan bool)
+	go func() {
+		done <- AwaitInterval(func() bool { return false }, 5, 12, WithClock(fakeClock))
+	}()
+	for _, step := range []time.Duration{5 * time.Second, 5 * time.Second, 2 * time.Second} {
+		fakeClock.BlockUntil(1)
+		fakeClock.Advance(step)
+	}
+	if <-done {
+		t.Errorf("Expected AwaitInterval to time out when never ready")
+	}
+}
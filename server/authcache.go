@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/redis/go-redis/v9"
+	utils "github.com/tozny/utils-go"
+	"github.com/tozny/utils-go/cache"
+	"github.com/tozny/utils-go/clock"
+	"github.com/tozny/utils-go/logging"
+)
+
+const (
+	// DefaultTokenCacheSize is the number of tokens held in the in-process cache
+	DefaultTokenCacheSize = 10000
+	// DefaultTokenCacheTTL is how long a valid token's client ID is cached
+	DefaultTokenCacheTTL = 5 * time.Minute
+	// DefaultTokenCacheNegativeTTL is how long an invalid token is remembered as invalid
+	DefaultTokenCacheNegativeTTL = 10 * time.Second
+	// DefaultTokenCacheRedisPrefix is the prefix of keys written to the shared Redis tier
+	DefaultTokenCacheRedisPrefix = "authcache"
+)
+
+// TokenCacheConfig wraps configuration for CachingTokenAuthenticator. Zero values use the package defaults.
+type TokenCacheConfig struct {
+	Size        int                                  // Number of tokens held in the in-process LRU
+	TTL         time.Duration                        // How long valid results are cached, never past the token's expiry
+	NegativeTTL time.Duration                        // How long invalid results are cached, negative disables negative caching
+	Redis       redis.Cmdable                        // Shared cache tier (e.g. from cache.NewClient) checked after the LRU, nil disables
+	RedisPrefix string                               // Prefix of keys written to Redis
+	Expiry      func(token string) (time.Time, bool) // Returns when token expires and whether it is known, nil reads the exp claim of JWTs
+	Logger      logging.Logger                       // Logger for Redis failures, nil disables logging
+	Clock       clock.Clock                          // Source of time for expiry, nil uses the system clock
+	// RedisKey (up to 64 bytes) authenticates entries written to Redis with a BLAKE2b MAC,
+	// entries read without a valid MAC are ignored. Without a key anyone able to write to
+	// Redis can mint valid results for any token, so Redis must then be fully trusted.
+	RedisKey []byte
+}
+
+// tokenCacheEntry is the cached result of authenticating a token.
+type tokenCacheEntry struct {
+	ClientID string    `json:"client_id"`
+	Valid    bool      `json:"valid"`
+	Expires  time.Time `json:"expires"`
+}
+
+// sharedTokenCacheEntry is a tokenCacheEntry as written to Redis, with the MAC of
+// its Redis key and encoded entry if a RedisKey is configured.
+type sharedTokenCacheEntry struct {
+	Entry json.RawMessage `json:"entry"`
+	MAC   string          `json:"mac,omitempty"`
+}
+
+// cachingTokenAuthenticator decorates an E3DBTokenAuthenticator, caching its results.
+type cachingTokenAuthenticator struct {
+	E3DBTokenAuthenticator
+	config TokenCacheConfig
+	lru    *cache.LRU[string, tokenCacheEntry]
+}
+
+// CachingTokenAuthenticator wraps auth so that the result of authenticating each token
+// is cached, in process and optionally in Redis, instead of calling auth for every request.
+// Tokens are cached under a BLAKE2b hash so they are never stored in the clear. Valid
+// results are cached for config.TTL but never past the token's expiry, and invalid results
+// for the shorter config.NegativeTTL to blunt repeated guessing. Errors are never cached,
+// and a failing Redis tier is logged and skipped rather than failing authentication.
+// Configure config.RedisKey unless everything able to write to Redis is fully trusted.
+func CachingTokenAuthenticator(auth E3DBTokenAuthenticator, config TokenCacheConfig) E3DBTokenAuthenticator {
+	if config.Size <= 0 {
+		config.Size = DefaultTokenCacheSize
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultTokenCacheTTL
+	}
+	if config.NegativeTTL == 0 {
+		config.NegativeTTL = DefaultTokenCacheNegativeTTL
+	}
+	if config.RedisPrefix == "" {
+		config.RedisPrefix = DefaultTokenCacheRedisPrefix
+	}
+	if config.Expiry == nil {
+		config.Expiry = jwtExpiry
+	}
+	config.Clock = clock.OrNew(config.Clock)
+	return &cachingTokenAuthenticator{
+		E3DBTokenAuthenticator: auth,
+		config:                 config,
+		lru:                    cache.NewLRU[string, tokenCacheEntry](config.Size, config.Clock),
+	}
+}
+
+// CachingRequestAuthenticator is TokenRequestAuthenticator for auth wrapped by
+// CachingTokenAuthenticator, e.g. for passing to RequestAuthMiddleware or registering
+// with a CompositeAuthenticator. Internal requires the token to belong to an internal client.
+func CachingRequestAuthenticator(auth E3DBTokenAuthenticator, internal bool, config TokenCacheConfig) PrincipalAuthenticator {
+	return TokenRequestAuthenticator(CachingTokenAuthenticator(auth, config), internal)
+}
+
+func (auth *cachingTokenAuthenticator) AuthenticateE3DBClient(ctx context.Context, token string, internal bool) (string, bool, error) {
+	hash, err := utils.HashAndEncodeString(token)
+	if err != nil {
+		return auth.E3DBTokenAuthenticator.AuthenticateE3DBClient(ctx, token, internal)
+	}
+	// Whether the client must be internal changes the result, so is part of the key
+	key := "external:" + hash
+	if internal {
+		key = "internal:" + hash
+	}
+	now := auth.config.Clock.Now()
+	if entry, ok := auth.lru.Get(key); ok {
+		return entry.ClientID, entry.Valid, nil
+	}
+	if entry, ok := auth.getShared(ctx, key, now); ok {
+		auth.lru.Set(key, entry, entry.Expires.Sub(now))
+		return entry.ClientID, entry.Valid, nil
+	}
+	clientID, valid, err := auth.E3DBTokenAuthenticator.AuthenticateE3DBClient(ctx, token, internal)
+	if err != nil {
+		return clientID, valid, err
+	}
+	ttl := auth.ttl(token, valid, now)
+	if ttl > 0 {
+		entry := tokenCacheEntry{ClientID: clientID, Valid: valid, Expires: now.Add(ttl)}
+		auth.lru.Set(key, entry, ttl)
+		auth.setShared(ctx, key, entry, ttl)
+	}
+	return clientID, valid, nil
+}
+
+// ttl returns how long the result of authenticating token may be cached from now.
+func (auth *cachingTokenAuthenticator) ttl(token string, valid bool, now time.Time) time.Duration {
+	if !valid {
+		return auth.config.NegativeTTL
+	}
+	ttl := auth.config.TTL
+	if expires, ok := auth.config.Expiry(token); ok && expires.Sub(now) < ttl {
+		ttl = expires.Sub(now)
+	}
+	return ttl
+}
+
+// getShared returns the unexpired entry for key from the Redis tier (if configured).
+func (auth *cachingTokenAuthenticator) getShared(ctx context.Context, key string, now time.Time) (tokenCacheEntry, bool) {
+	var entry tokenCacheEntry
+	if auth.config.Redis == nil {
+		return entry, false
+	}
+	redisKey := auth.config.RedisPrefix + ":" + key
+	raw, err := auth.config.Redis.Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		return entry, false
+	}
+	if err != nil {
+		auth.logf("CachingTokenAuthenticator: error reading from redis: %s\n", err)
+		return entry, false
+	}
+	var shared sharedTokenCacheEntry
+	if err := json.Unmarshal(raw, &shared); err != nil {
+		auth.logf("CachingTokenAuthenticator: error decoding cached entry: %s\n", err)
+		return entry, false
+	}
+	if len(auth.config.RedisKey) > 0 {
+		authentic, err := utils.VerifyHash(shared.MAC, redisKey+"\n"+string(shared.Entry), auth.config.RedisKey)
+		if err != nil || !authentic {
+			auth.logf("CachingTokenAuthenticator: ignoring cached entry %s with invalid MAC\n", redisKey)
+			return entry, false
+		}
+	}
+	if err := json.Unmarshal(shared.Entry, &entry); err != nil {
+		auth.logf("CachingTokenAuthenticator: error decoding cached entry: %s\n", err)
+		return entry, false
+	}
+	return entry, entry.Expires.After(now)
+}
+
+// setShared writes entry for key to the Redis tier (if configured).
+func (auth *cachingTokenAuthenticator) setShared(ctx context.Context, key string, entry tokenCacheEntry, ttl time.Duration) {
+	if auth.config.Redis == nil {
+		return
+	}
+	redisKey := auth.config.RedisPrefix + ":" + key
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		auth.logf("CachingTokenAuthenticator: error encoding cache entry: %s\n", err)
+		return
+	}
+	shared := sharedTokenCacheEntry{Entry: encoded}
+	if len(auth.config.RedisKey) > 0 {
+		// The Redis key is included so an entry can not be copied to another token's key
+		shared.MAC, err = utils.MACAndEncodeString(auth.config.RedisKey, redisKey+"\n"+string(encoded))
+		if err != nil {
+			auth.logf("CachingTokenAuthenticator: error authenticating cache entry: %s\n", err)
+			return
+		}
+	}
+	raw, err := json.Marshal(shared)
+	if err != nil {
+		auth.logf("CachingTokenAuthenticator: error encoding cache entry: %s\n", err)
+		return
+	}
+	if err := auth.config.Redis.Set(ctx, redisKey, raw, ttl).Err(); err != nil {
+		auth.logf("CachingTokenAuthenticator: error writing to redis: %s\n", err)
+	}
+}
+
+// logf logs a Redis failure if a logger is configured.
+func (auth *cachingTokenAuthenticator) logf(format string, v ...interface{}) {
+	if auth.config.Logger != nil {
+		auth.config.Logger.Errorf(format, v...)
+	}
+}
+
+// jwtExpiry returns the expiry of token read from its exp claim without verifying it,
+// which is safe as the token has already been verified by the wrapped authenticator.
+func jwtExpiry(token string) (time.Time, bool) {
+	claims, err := jwt.ParseWithoutCheck([]byte(token))
+	if err != nil || claims.Expires == nil {
+		return time.Time{}, false
+	}
+	return claims.Expires.Time(), true
+}
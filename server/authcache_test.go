@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	utils "github.com/tozny/utils-go"
+	"github.com/tozny/utils-go/clock"
+)
+
+// fakeRedis is an in-memory redis.Cmdable supporting only Get and Set.
+type fakeRedis struct {
+	redis.Cmdable
+	mutex  sync.Mutex
+	values map[string]string
+	ttls   map[string]time.Duration
+	err    error
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{values: map[string]string{}, ttls: map[string]time.Duration{}}
+}
+
+func (f *fakeRedis) Get(ctx context.Context, key string) *redis.StringCmd {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err != nil {
+		return redis.NewStringResult("", f.err)
+	}
+	value, ok := f.values[key]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+func (f *fakeRedis) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err != nil {
+		return redis.NewStatusResult("", f.err)
+	}
+	f.values[key] = string(value.([]byte))
+	f.ttls[key] = expiration
+	return redis.NewStatusResult("OK", nil)
+}
+
+// countingTokens authenticates "token-<client>" bearer tokens as <client>, only
+// accepting clients prefixed with "internal-" as internal and failing for "token-error",
+// counting every call.
+type countingTokens struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (c *countingTokens) AuthenticateE3DBClient(ctx context.Context, token string, internal bool) (string, bool, error) {
+	c.mutex.Lock()
+	c.calls++
+	c.mutex.Unlock()
+	if token == "token-error" {
+		return "", false, errors.New("authentication service unavailable")
+	}
+	clientID := strings.TrimPrefix(token, "token-")
+	if clientID == token || (internal && !strings.HasPrefix(clientID, "internal-")) {
+		return "", false, nil
+	}
+	return clientID, true, nil
+}
+
+// Calls returns the number of calls made so far.
+func (c *countingTokens) Calls() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls
+}
+
+// noExpiry reports no token as having a known expiry.
+func noExpiry(token string) (time.Time, bool) { return time.Time{}, false }
+
+func TestCachingTokenAuthenticatorCachesResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		internal bool
+		clientID string
+		valid    bool
+		calls    int
+	}{
+		{"valid", "token-client-1", false, "client-1", true, 1},
+		{"invalid", "unknown", false, "", false, 1},
+		{"errors not cached", "token-error", false, "", false, 3},
+		{"internal", "token-internal-1", true, "internal-1", true, 1},
+		{"external client as internal", "token-client-1", true, "", false, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens := &countingTokens{}
+			auth := CachingTokenAuthenticator(tokens, TokenCacheConfig{Expiry: noExpiry})
+			for i := 0; i < 3; i++ {
+				clientID, valid, _ := auth.AuthenticateE3DBClient(context.Background(), test.token, test.internal)
+				if clientID != test.clientID || valid != test.valid {
+					t.Errorf("Expected %q and %t, got %q and %t", test.clientID, test.valid, clientID, valid)
+				}
+			}
+			if tokens.Calls() != test.calls {
+				t.Errorf("Expected %d calls to the wrapped authenticator, got %d", test.calls, tokens.Calls())
+			}
+		})
+	}
+}
+
+func TestCachingTokenAuthenticatorSplitsInternalAndExternal(t *testing.T) {
+	tokens := &countingTokens{}
+	auth := CachingTokenAuthenticator(tokens, TokenCacheConfig{Expiry: noExpiry})
+	ctx := context.Background()
+	if _, valid, _ := auth.AuthenticateE3DBClient(ctx, "token-client-1", false); !valid {
+		t.Errorf("Expected the token to be valid for an external client")
+	}
+	if _, valid, _ := auth.AuthenticateE3DBClient(ctx, "token-client-1", true); valid {
+		t.Errorf("Expected a cached external result not to authenticate an internal client")
+	}
+	if tokens.Calls() != 2 {
+		t.Errorf("Expected internal and external results to be cached separately, got %d calls", tokens.Calls())
+	}
+}
+
+func TestCachingTokenAuthenticatorExpiry(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name        string
+		token       string
+		negativeTTL time.Duration
+		expires     time.Time
+		advance     time.Duration
+		calls       int
+	}{
+		{"within ttl", "token-client-1", 0, time.Time{}, 4 * time.Minute, 1},
+		{"past ttl", "token-client-1", 0, time.Time{}, 6 * time.Minute, 2},
+		{"capped by token expiry", "token-client-1", 0, start.Add(30 * time.Second), 31 * time.Second, 2},
+		{"within token expiry", "token-client-1", 0, start.Add(30 * time.Second), 29 * time.Second, 1},
+		{"expired token not cached", "token-client-1", 0, start.Add(-time.Second), 0, 2},
+		{"within negative ttl", "unknown", 0, time.Time{}, 9 * time.Second, 1},
+		{"past negative ttl", "unknown", 0, time.Time{}, 11 * time.Second, 2},
+		{"configured negative ttl", "unknown", time.Minute, time.Time{}, 59 * time.Second, 1},
+		{"negative caching disabled", "unknown", -1, time.Time{}, 0, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens := &countingTokens{}
+			fakeClock := clock.NewFake(start)
+			auth := CachingTokenAuthenticator(tokens, TokenCacheConfig{
+				NegativeTTL: test.negativeTTL,
+				Clock:       fakeClock,
+				Expiry: func(token string) (time.Time, bool) {
+					return test.expires, !test.expires.IsZero()
+				},
+			})
+			auth.AuthenticateE3DBClient(context.Background(), test.token, false)
+			fakeClock.Advance(test.advance)
+			auth.AuthenticateE3DBClient(context.Background(), test.token, false)
+			if tokens.Calls() != test.calls {
+				t.Errorf("Expected %d calls to the wrapped authenticator, got %d", test.calls, tokens.Calls())
+			}
+		})
+	}
+}
+
+func TestCachingTokenAuthenticatorSharesResultsThroughRedis(t *testing.T) {
+	shared := newFakeRedis()
+	start := time.Now()
+	config := TokenCacheConfig{
+		Redis:    shared,
+		RedisKey: []byte("redis-mac-key"),
+		Clock:    clock.NewFake(start),
+		Expiry:   func(token string) (time.Time, bool) { return start.Add(time.Minute), true },
+	}
+	tokens := &countingTokens{}
+	CachingTokenAuthenticator(tokens, config).AuthenticateE3DBClient(context.Background(), "token-client-1", false)
+	clientID, valid, err := CachingTokenAuthenticator(tokens, config).AuthenticateE3DBClient(context.Background(), "token-client-1", false)
+	if clientID != "client-1" || !valid || err != nil {
+		t.Errorf("Expected the shared result, got %q, %t and %v", clientID, valid, err)
+	}
+	if tokens.Calls() != 1 {
+		t.Errorf("Expected a second process to use the result from redis, got %d calls", tokens.Calls())
+	}
+	for key, ttl := range shared.ttls {
+		if ttl != time.Minute {
+			t.Errorf("Expected %s to be written with the token's remaining lifetime, got %s", key, ttl)
+		}
+	}
+}
+
+func TestCachingTokenAuthenticatorFallsBackWhenRedisFails(t *testing.T) {
+	shared := newFakeRedis()
+	shared.err = errors.New("dial tcp redis:6379: connection refused")
+	logger := &testLogger{}
+	tokens := &countingTokens{}
+	auth := CachingTokenAuthenticator(tokens, TokenCacheConfig{Redis: shared, RedisKey: []byte("redis-mac-key"), Logger: logger, Expiry: noExpiry})
+	for i := 0; i < 2; i++ {
+		if clientID, valid, err := auth.AuthenticateE3DBClient(context.Background(), "token-client-1", false); clientID != "client-1" || !valid || err != nil {
+			t.Errorf("Expected authentication to succeed without redis, got %q, %t and %v", clientID, valid, err)
+		}
+	}
+	if tokens.Calls() != 1 {
+		t.Errorf("Expected the in-process cache to be used without redis, got %d calls", tokens.Calls())
+	}
+	if !strings.Contains(logger.String(), "connection refused") {
+		t.Errorf("Expected redis failures to be logged, got\n%s", logger)
+	}
+}
+
+func TestCachingTokenAuthenticatorRejectsForgedRedisEntries(t *testing.T) {
+	keyFor := func(token string) string {
+		hash, _ := utils.HashAndEncodeString(token)
+		return DefaultTokenCacheRedisPrefix + ":external:" + hash
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	unsigned := `{"entry":{"client_id":"attacker","valid":true,"expires":"` + expires + `"}}`
+	tests := []struct {
+		name  string
+		forge func(shared *fakeRedis)
+	}{
+		{"unsigned entry", func(shared *fakeRedis) {
+			shared.values[keyFor("token-victim")] = unsigned
+		}},
+		{"entry signed with another key", func(shared *fakeRedis) {
+			other := CachingTokenAuthenticator(&countingTokens{}, TokenCacheConfig{Redis: shared, RedisKey: []byte("other-key"), Expiry: noExpiry})
+			other.AuthenticateE3DBClient(context.Background(), "token-victim", false)
+		}},
+		{"entry copied from another token", func(shared *fakeRedis) {
+			auth := CachingTokenAuthenticator(&countingTokens{}, TokenCacheConfig{Redis: shared, RedisKey: []byte("redis-mac-key"), Expiry: noExpiry})
+			auth.AuthenticateE3DBClient(context.Background(), "token-attacker", false)
+			shared.values[keyFor("token-victim")] = shared.values[keyFor("token-attacker")]
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shared := newFakeRedis()
+			test.forge(shared)
+			tokens := &countingTokens{}
+			auth := CachingTokenAuthenticator(tokens, TokenCacheConfig{Redis: shared, RedisKey: []byte("redis-mac-key"), Logger: &testLogger{}, Expiry: noExpiry})
+			if clientID, _, _ := auth.AuthenticateE3DBClient(context.Background(), "token-victim", false); clientID != "victim" {
+				t.Errorf("Expected the forged entry to be ignored, got %q", clientID)
+			}
+			if tokens.Calls() != 1 {
+				t.Errorf("Expected the wrapped authenticator to be called, got %d calls", tokens.Calls())
+			}
+		})
+	}
+}
+
+func TestCachingRequestAuthenticatorReportsPrincipal(t *testing.T) {
+	tokens := &countingTokens{}
+	var principals []Principal
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+		principals = append(principals, principal)
+	}), RequestAuthMiddleware(CachingRequestAuthenticator(tokens, true, TokenCacheConfig{Expiry: noExpiry}), &testLogger{}))
+	for _, token := range []string{"token-internal-1", "token-internal-1", "token-client-1"} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/things", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	if len(principals) != 2 || tokens.Calls() != 2 {
+		t.Fatalf("Expected only internal clients to be authenticated and results cached, got %+v with %d calls", principals, tokens.Calls())
+	}
+	if principals[1].ClientID != "internal-1" || principals[1].Token != "token-internal-1" || principals[1].Method != AuthMethodBearerToken {
+		t.Errorf("Expected a bearer token principal from the cached result, got %+v", principals[1])
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tozny/utils-go/breaker"
+)
+
+var (
+	// ErrInvalidAuthScheme is returned when an AuthScheme has no authenticator or no way to match requests
+	ErrInvalidAuthScheme = errors.New("invalid authentication scheme")
+)
+
+// AuthScheme is a way of authenticating requests registered with a CompositeAuthenticator.
+// A request is handled by the scheme if it matches any of Type, Header or Match.
+type AuthScheme struct {
+	Name          AuthMethod                 // Recorded as the Method of principals this scheme authenticates, defaults to Type or Header in lower case
+	Type          string                     // Authorization header type this scheme handles (e.g. "Bearer"), matched case-insensitively
+	Header        string                     // Header whose presence marks requests for this scheme (e.g. "X-API-Key")
+	Match         func(r *http.Request) bool // Reports whether r carries credentials for this scheme, for markers other than a header
+	Authenticator RequestAuthenticator       // Authenticates requests handled by this scheme
+}
+
+// matches reports whether r carries credentials for the scheme.
+func (s AuthScheme) matches(r *http.Request) bool {
+	if s.Type != "" {
+		if authType, _, err := ExtractAuthorization(r); err == nil && strings.EqualFold(authType, s.Type) {
+			return true
+		}
+	}
+	if s.Header != "" && r.Header.Get(s.Header) != "" {
+		return true
+	}
+	return s.Match != nil && s.Match(r)
+}
+
+// CompositeAuthenticator is a PrincipalAuthenticator which authenticates requests with
+// whichever of several schemes (e.g. bearer tokens, API keys and signed requests) the
+// request carries credentials for.
+type CompositeAuthenticator struct {
+	schemes []AuthScheme
+}
+
+// NewCompositeAuthenticator returns a new CompositeAuthenticator trying schemes in the
+// order provided, or ErrInvalidAuthScheme if any scheme has no authenticator or no way
+// to match requests.
+func NewCompositeAuthenticator(schemes ...AuthScheme) (*CompositeAuthenticator, error) {
+	schemes = append([]AuthScheme{}, schemes...)
+	for index, scheme := range schemes {
+		if scheme.Authenticator == nil {
+			return nil, fmt.Errorf("%w: scheme %d has no authenticator", ErrInvalidAuthScheme, index)
+		}
+		if scheme.Type == "" && scheme.Header == "" && scheme.Match == nil {
+			return nil, fmt.Errorf("%w: scheme %d has no Type, Header or Match", ErrInvalidAuthScheme, index)
+		}
+		if scheme.Name == "" {
+			schemes[index].Name = AuthMethod(strings.ToLower(scheme.Type))
+		}
+		if schemes[index].Name == "" {
+			schemes[index].Name = AuthMethod(strings.ToLower(scheme.Header))
+		}
+	}
+	return &CompositeAuthenticator{schemes: schemes}, nil
+}
+
+// AuthenticateRequest authenticates r, returning the authenticated client ID and error (if any).
+func (auth *CompositeAuthenticator) AuthenticateRequest(ctx context.Context, r *http.Request) (string, error) {
+	principal, err := auth.AuthenticatePrincipal(ctx, r)
+	return principal.ClientID, err
+}
+
+// AuthenticatePrincipal tries each scheme matching r in order, returning the principal
+// from the first to succeed with its Method set to the scheme's Name. If none succeed the
+// returned error wraps ErrorInvalidAuthentication, or ErrorAuthenticationUnavailable if any
+// scheme's authenticator could not be reached, with each scheme's failure for logging.
+// RequestAuthMiddleware and WriteError only ever respond with the static sentinel message.
+func (auth *CompositeAuthenticator) AuthenticatePrincipal(ctx context.Context, r *http.Request) (Principal, error) {
+	var failures []string
+	var unavailable bool
+	for _, scheme := range auth.schemes {
+		if !scheme.matches(r) {
+			continue
+		}
+		principal, err := authenticatePrincipal(ctx, scheme.Authenticator, r)
+		if err == nil {
+			principal.Method = scheme.Name
+			return principal, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", scheme.Name, err))
+		if errors.Is(err, breaker.ErrOpen) || errors.Is(err, breaker.ErrTooManyRequests) || errors.Is(err, ErrorAuthenticationUnavailable) {
+			unavailable = true
+		}
+	}
+	if len(failures) == 0 {
+		return Principal{}, fmt.Errorf("%w: no authentication scheme matches request", ErrorInvalidAuthentication)
+	}
+	if unavailable {
+		return Principal{}, fmt.Errorf("%w: %s", ErrorAuthenticationUnavailable, strings.Join(failures, "; "))
+	}
+	return Principal{}, fmt.Errorf("%w: %s", ErrorInvalidAuthentication, strings.Join(failures, "; "))
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tozny/utils-go/breaker"
+)
+
+// apiKeys authenticates "key-<client>" X-Api-Key headers as <client>, with "key-down"
+// reporting the key service unavailable.
+var apiKeys = requestAuthenticatorFunc(func(ctx context.Context, r *http.Request) (string, error) {
+	key := r.Header.Get("X-Api-Key")
+	if key == "key-down" {
+		return "", breaker.ErrOpen
+	}
+	if !strings.HasPrefix(key, "key-") {
+		return "", errors.New("unknown api key " + key)
+	}
+	return strings.TrimPrefix(key, "key-"), nil
+})
+
+// signedRequests authenticates requests with a "signed-by" query parameter as its value.
+var signedRequests = requestAuthenticatorFunc(func(ctx context.Context, r *http.Request) (string, error) {
+	return r.URL.Query().Get("signed-by"), nil
+})
+
+func TestNewCompositeAuthenticatorValidatesSchemes(t *testing.T) {
+	if _, err := NewCompositeAuthenticator(AuthScheme{Type: "Bearer"}); !errors.Is(err, ErrInvalidAuthScheme) {
+		t.Errorf("Expected a scheme without an authenticator to be rejected, got %v", err)
+	}
+	if _, err := NewCompositeAuthenticator(AuthScheme{Authenticator: apiKeys}); !errors.Is(err, ErrInvalidAuthScheme) {
+		t.Errorf("Expected a scheme without a matcher to be rejected, got %v", err)
+	}
+}
+
+func TestCompositeAuthenticatorSelectsScheme(t *testing.T) {
+	auth, err := NewCompositeAuthenticator(
+		AuthScheme{Type: "Bearer", Authenticator: TokenRequestAuthenticator(staticTokens, false)},
+		AuthScheme{Header: "X-Api-Key", Authenticator: apiKeys},
+		AuthScheme{Name: "signed", Match: func(r *http.Request) bool { return r.URL.Query().Has("signed-by") }, Authenticator: signedRequests},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		query         string
+		authorization string
+		apiKey        string
+		status        int
+		clientID      string
+		method        AuthMethod
+	}{
+		{"bearer token", "", "Bearer token-client-1", "", http.StatusOK, "client-1", "bearer"},
+		{"bearer type case insensitive", "", "bearer token-client-1", "", http.StatusOK, "client-1", "bearer"},
+		{"api key", "", "", "key-client-2", http.StatusOK, "client-2", "x-api-key"},
+		{"signed request", "?signed-by=client-3", "", "", http.StatusOK, "client-3", "signed"},
+		{"first matching scheme wins", "", "Bearer token-client-1", "key-client-2", http.StatusOK, "client-1", "bearer"},
+		{"falls through failed schemes", "", "Bearer unknown", "key-client-2", http.StatusOK, "client-2", "x-api-key"},
+		{"no credentials", "", "", "", http.StatusUnauthorized, "", ""},
+		{"unsupported type", "", "Basic dXNlcjpwYXNz", "", http.StatusUnauthorized, "", ""},
+		{"invalid credentials", "", "Bearer unknown", "unknown", http.StatusUnauthorized, "", ""},
+		{"scheme unavailable", "", "Bearer unknown", "key-down", http.StatusServiceUnavailable, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var principal Principal
+			handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, _ = PrincipalFrom(r.Context())
+			}), RequestAuthMiddleware(auth, &testLogger{}))
+			request := httptest.NewRequest(http.MethodGet, "/v1/things"+test.query, nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.apiKey != "" {
+				request.Header.Set("X-Api-Key", test.apiKey)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, recorder.Code)
+			}
+			if principal.ClientID != test.clientID || principal.Method != test.method {
+				t.Errorf("Expected client %q authenticated by %q, got %+v", test.clientID, test.method, principal)
+			}
+			if strings.Contains(recorder.Body.String(), "unknown") {
+				t.Errorf("Expected scheme failures not to be exposed, got %s", recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tozny/utils-go/auth"
+)
+
+// contextKey is the type of context keys defined by this package.
+type contextKey string
+
+const (
+	// principalContextKey is the context key for the authenticated Principal
+	principalContextKey contextKey = "principal"
+	// principalSlotContextKey is the context key for the slot authentication middleware
+	// records the Principal in for middleware applied outside of it
+	principalSlotContextKey contextKey = "principalSlot"
+)
+
+// AuthMethod identifies how a principal was authenticated.
+type AuthMethod string
+
+const (
+	// AuthMethodBearerToken principals presented an e3db Oauth2 bearer token
+	AuthMethodBearerToken AuthMethod = "bearer_token"
+	// AuthMethodRequest principals were authenticated by a RequestAuthenticator
+	// which reports only a client ID
+	AuthMethodRequest AuthMethod = "request"
+)
+
+// Principal is the authenticated identity a request was made by.
+type Principal struct {
+	ClientID string                 // ID of the authenticated client
+	Token    string                 // Credential the client authenticated with (if any)
+	Claims   map[string]interface{} // Claims asserted by the credential (if any)
+	JWT      *auth.Claims           // Parsed claims of a locally verified JWT (if any)
+	Method   AuthMethod             // How the client was authenticated
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying the authenticated principal.
+func ContextWithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFrom returns the authenticated principal carried by ctx and whether there was one.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(Principal)
+	return principal, ok
+}
+
+// ContextWithClientID returns a copy of ctx carrying a principal with only the
+// authenticated client ID, e.g. for testing handlers without authentication middleware.
+func ContextWithClientID(ctx context.Context, clientID string) context.Context {
+	return ContextWithPrincipal(ctx, Principal{ClientID: clientID, Method: AuthMethodRequest})
+}
+
+// ClientIDFromContext returns the client ID of the authenticated principal carried by ctx
+// and whether there was one.
+func ClientIDFromContext(ctx context.Context) (string, bool) {
+	principal, ok := PrincipalFrom(ctx)
+	return principal.ClientID, ok && principal.ClientID != ""
+}
+
+// principalSlot records the principal authenticated further down a middleware chain,
+// as middleware applied outside of authentication (e.g. access logging) only has the
+// request context from before authentication.
+type principalSlot struct {
+	mutex     sync.Mutex
+	principal Principal
+}
+
+// withPrincipalSlot returns a copy of ctx carrying an empty slot for the principal
+// authenticated further down the middleware chain, reusing any slot ctx already has.
+func withPrincipalSlot(ctx context.Context) (context.Context, *principalSlot) {
+	if slot, ok := ctx.Value(principalSlotContextKey).(*principalSlot); ok {
+		return ctx, slot
+	}
+	slot := &principalSlot{}
+	return context.WithValue(ctx, principalSlotContextKey, slot), slot
+}
+
+// recordPrincipal stores principal in the slot carried by ctx (if any).
+func recordPrincipal(ctx context.Context, principal Principal) {
+	if slot, ok := ctx.Value(principalSlotContextKey).(*principalSlot); ok {
+		slot.mutex.Lock()
+		slot.principal = principal
+		slot.mutex.Unlock()
+	}
+}
+
+// clientID returns the client ID of the principal authenticated for ctx, either
+// before the slot was created or further down the middleware chain.
+func (s *principalSlot) clientID(ctx context.Context) string {
+	if clientID, ok := ClientIDFromContext(ctx); ok {
+		return clientID
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.principal.ClientID
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CORSAllowAll allows any origin or request header when used in a CORSConfig allowlist
+	CORSAllowAll = "*"
+)
+
+var (
+	// DefaultCORSMethods are the methods allowed for cross origin requests when none are configured
+	DefaultCORSMethods = []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodHead, http.MethodOptions,
+	}
+	// DefaultCORSAllowedHeaders are the request headers allowed for cross origin requests when none are configured
+	DefaultCORSAllowedHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Request-ID"}
+)
+
+// CORSConfig wraps configuration for CORSMiddlewareWithConfig.
+type CORSConfig struct {
+	AllowedOrigins   []string      // Exact origins (https://app.example.com), wildcard subdomains (https://*.example.com) or CORSAllowAll (ignored with AllowCredentials)
+	AllowedMethods   []string      // Methods allowed for cross origin requests, nil uses DefaultCORSMethods
+	AllowedHeaders   []string      // Request headers allowed for cross origin requests or CORSAllowAll, nil uses DefaultCORSAllowedHeaders
+	ExposedHeaders   []string      // Response headers scripts are allowed to read
+	AllowCredentials bool          // Whether requests may include cookies and authorization headers
+	MaxAge           time.Duration // How long browsers may cache preflight responses, zero omits the header
+}
+
+// corsPolicy is a CORSConfig prepared for matching requests.
+type corsPolicy struct {
+	config        CORSConfig
+	allowAll      bool
+	origins       map[string]bool
+	wildcards     [][2]string // Scheme and host suffix pairs, e.g. {"https://", ".example.com"}
+	methods       map[string]bool
+	headers       map[string]bool
+	allowHeaders  bool
+	methodList    string
+	exposedHeader string
+}
+
+// newCORSPolicy prepares config for matching requests. CORSAllowAll is ignored when
+// credentials are allowed, as reflecting any origin with credentials would let every
+// website read responses on behalf of logged in users.
+func newCORSPolicy(config CORSConfig) *corsPolicy {
+	if config.AllowedMethods == nil {
+		config.AllowedMethods = DefaultCORSMethods
+	}
+	if config.AllowedHeaders == nil {
+		config.AllowedHeaders = DefaultCORSAllowedHeaders
+	}
+	policy := &corsPolicy{
+		config:        config,
+		origins:       map[string]bool{},
+		methods:       map[string]bool{},
+		headers:       map[string]bool{},
+		methodList:    strings.Join(config.AllowedMethods, ", "),
+		exposedHeader: strings.Join(config.ExposedHeaders, ", "),
+	}
+	for _, origin := range config.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == CORSAllowAll:
+			policy.allowAll = !config.AllowCredentials
+		case strings.Contains(origin, "://*."):
+			parts := strings.SplitN(origin, "*", 2)
+			policy.wildcards = append(policy.wildcards, [2]string{parts[0], parts[1]})
+		default:
+			policy.origins[origin] = true
+		}
+	}
+	for _, method := range config.AllowedMethods {
+		policy.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range config.AllowedHeaders {
+		if header == CORSAllowAll {
+			policy.allowHeaders = true
+		}
+		policy.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return policy
+}
+
+// allowOrigin reports whether origin may make cross origin requests.
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, wildcard := range p.wildcards {
+		scheme, suffix := wildcard[0], wildcard[1]
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeadersList reports whether every header in the comma separated list requested may be sent.
+func (p *corsPolicy) allowHeadersList(requested string) bool {
+	if p.allowHeaders {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin sets the allowed origin for origin, which must have been allowed by allowOrigin,
+// reflecting it unless any origin is allowed. Any origin is only allowed without credentials.
+func (p *corsPolicy) setOrigin(header http.Header, origin string) {
+	if p.allowAll {
+		header.Set("Access-Control-Allow-Origin", CORSAllowAll)
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if p.config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// isPreflightRequest reports whether r is a CORS preflight request rather than an ordinary OPTIONS request.
+func isPreflightRequest(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// CORSMiddlewareWithConfig provides http middleware for allowing cross origin requests
+// from the origins in config. Preflight requests, OPTIONS requests with an
+// Access-Control-Request-Method header, are answered with 204 No Content and the
+// allowed methods and headers. Other requests from allowed origins are passed on with
+// the allowed origin reflected, and requests from other origins are passed on without
+// CORS headers so that browsers block scripts from reading the response.
+func CORSMiddlewareWithConfig(config CORSConfig) Middleware {
+	policy := newCORSPolicy(config)
+	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		origin := r.Header.Get("Origin")
+		if isPreflightRequest(r) {
+			header.Add("Vary", "Origin")
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			requestedMethod := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+			requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if policy.allowOrigin(origin) && policy.methods[requestedMethod] && policy.allowHeadersList(requestedHeaders) {
+				policy.setOrigin(header, origin)
+				header.Set("Access-Control-Allow-Methods", policy.methodList)
+				if requestedHeaders != "" {
+					// Requested headers were all allowed above, so echoing them allows exactly those
+					header.Set("Access-Control-Allow-Headers", requestedHeaders)
+				}
+				if config.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !policy.allowAll {
+			// The response depends on the request's origin, so caches must key on it
+			header.Add("Vary", "Origin")
+		}
+		if origin != "" && policy.allowOrigin(origin) {
+			policy.setOrigin(header, origin)
+			if policy.exposedHeader != "" {
+				header.Set("Access-Control-Expose-Headers", policy.exposedHeader)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// corsRequest serves a request from origin through middleware, as a preflight if preflight is true.
+func corsRequest(middleware Middleware, origin string, preflight bool) *httptest.ResponseRecorder {
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), middleware)
+	method := http.MethodGet
+	if preflight {
+		method = http.MethodOptions
+	}
+	request := httptest.NewRequest(method, "/v1/things", nil)
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	if preflight {
+		request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		request.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestCORSMiddlewareWithConfigAllowlist(t *testing.T) {
+	middleware := CORSMiddlewareWithConfig(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.tozny.com"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	for _, origin := range []string{"https://app.example.com", "https://console.tozny.com"} {
+		recorder := corsRequest(middleware, origin, false)
+		if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != origin {
+			t.Errorf("Expected %s to be allowed, got %q", origin, allowed)
+		}
+		if recorder.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("Expected credentials to be allowed for %s", origin)
+		}
+	}
+	for _, origin := range []string{"https://evil.com", "https://tozny.com", "http://console.tozny.com", "https://app.example.com.evil.com"} {
+		recorder := corsRequest(middleware, origin, false)
+		if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != "" || recorder.Code != http.StatusOK {
+			t.Errorf("Expected %s to be passed on without CORS headers, got %q and %d", origin, allowed, recorder.Code)
+		}
+	}
+	recorder := corsRequest(middleware, "https://app.example.com", true)
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Access-Control-Allow-Methods") == "" || recorder.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("Expected preflight to be answered, got %d and %v", recorder.Code, recorder.Header())
+	}
+	if recorder := corsRequest(middleware, "https://evil.com", true); recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected preflight from a disallowed origin not to be allowed, got %v", recorder.Header())
+	}
+}
+
+func TestCORSMiddlewareWithConfigAllowAll(t *testing.T) {
+	recorder := corsRequest(CORSMiddlewareWithConfig(CORSConfig{AllowedOrigins: []string{CORSAllowAll}}), "https://any.example.com", false)
+	if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != CORSAllowAll {
+		t.Errorf("Expected a wildcard origin, got %q", allowed)
+	}
+	credentialed := CORSMiddlewareWithConfig(CORSConfig{
+		AllowedOrigins:   []string{CORSAllowAll, "https://app.example.com"},
+		AllowCredentials: true,
+	})
+	for _, preflight := range []bool{false, true} {
+		recorder := corsRequest(credentialed, "https://evil.com", preflight)
+		if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != "" {
+			t.Errorf("Expected credentialed wildcard not to reflect an unlisted origin (preflight %t), got %q", preflight, allowed)
+		}
+		if credentials := recorder.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+			t.Errorf("Expected no credentials for an unlisted origin (preflight %t), got %q", preflight, credentials)
+		}
+	}
+	recorder = corsRequest(credentialed, "https://app.example.com", false)
+	if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != "https://app.example.com" {
+		t.Errorf("Expected listed origin to still be allowed, got %q", allowed)
+	}
+}
+
+func TestCORSMiddlewareDropsCredentialsForWildcardOrigin(t *testing.T) {
+	middleware := CORSMiddleware(DefaultCORSHeaders)
+	for _, preflight := range []bool{false, true} {
+		recorder := corsRequest(middleware, "https://evil.com", preflight)
+		if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != CORSAllowAll {
+			t.Errorf("Expected the wildcard origin not to be replaced (preflight %t), got %q", preflight, allowed)
+		}
+		if credentials := recorder.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+			t.Errorf("Expected credentials not to be allowed with a wildcard origin (preflight %t), got %q", preflight, credentials)
+		}
+	}
+	explicit := CORSMiddleware([]http.Header{{
+		"Access-Control-Allow-Origin":      []string{"https://app.example.com"},
+		"Access-Control-Allow-Credentials": []string{"true"},
+	}})
+	recorder := corsRequest(explicit, "https://evil.com", false)
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || recorder.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Expected explicitly configured headers to be sent unchanged, got %v", recorder.Header())
+	}
+}
+
+func TestCORSMiddlewarePassesOrdinaryOptionsRequests(t *testing.T) {
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}), CORSMiddlewareWithConfig(CORSConfig{AllowedOrigins: []string{CORSAllowAll}}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodOptions, "/v1/things", nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("Expected OPTIONS without preflight headers to reach the handler, got %d", recorder.Code)
+	}
+}
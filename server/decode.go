@@ -0,0 +1,426 @@
+package server
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	apierrors "github.com/tozny/utils-go/errors"
+)
+
+const (
+	// DefaultMaxDepth is the deepest nesting of objects and arrays accepted by StrictDecodeOptions
+	DefaultMaxDepth = 32
+)
+
+var (
+	// StrictDecodeOptions rejects unknown fields, trailing data, non JSON content types,
+	// empty bodies and documents nested deeper than DefaultMaxDepth.
+	StrictDecodeOptions = DecodeOptions{
+		DisallowUnknownFields:  true,
+		DisallowTrailingData:   true,
+		RequireJSONContentType: true,
+		MaxDepth:               DefaultMaxDepth,
+	}
+	// errMaxDepth stops decoding once a document is nested too deeply
+	errMaxDepth = errors.New("maximum nesting depth exceeded")
+	// Types which decode themselves and so are not checked field by field
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// DecodeOptions configures how strictly UnmarshalJSONRequestWithOptions decodes a request body.
+type DecodeOptions struct {
+	DisallowUnknownFields  bool  // Reject object keys which do not match a field of the destination struct
+	DisallowTrailingData   bool  // Reject any data after the top level JSON value
+	RequireJSONContentType bool  // Reject non empty bodies whose Content-Type is not application/json
+	AllowEmptyBody         bool  // Leave the destination unchanged when the body is empty instead of rejecting it
+	MaxDepth               int   // Deepest nesting of objects and arrays accepted, zero for no limit
+	MaxBytes               int64 // Largest body accepted, zero uses DefaultMaxBodyBytes and negative for no limit
+}
+
+// UnmarshalJSONRequestWithOptions un-marshals a request object body JSON into the passed
+// interface as configured by options. Every problem found with the body's fields (unknown
+// fields, values of the wrong type and excessive nesting) is reported together in a single
+// invalid argument error whose Fields list each offending field path and the reason it was
+// rejected, which WriteError returns to the caller. A body with the wrong Content-Type is
+// reported as unsupported and one which is too large as a 413.
+func UnmarshalJSONRequestWithOptions(r *http.Request, obj interface{}, options DecodeOptions) error {
+	maxBytes := options.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	body := r.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(nil, body, maxBytes)
+	}
+	bodyBytes, err := ioutil.ReadAll(body)
+	if err != nil {
+		return bodyReadError(err)
+	}
+	if len(bytes.TrimSpace(bodyBytes)) == 0 {
+		if options.AllowEmptyBody {
+			return nil
+		}
+		return apierrors.InvalidArgument("Request body is required")
+	}
+	if options.RequireJSONContentType && !hasJSONContentType(r) {
+		return apierrors.New(apierrors.CodeUnsupportedRequest, "Content-Type must be application/json").
+			WithDetail("received Content-Type %q", r.Header.Get("Content-Type"))
+	}
+	if err := checkJSONShape(bodyBytes, reflect.TypeOf(obj), options); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	if options.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(obj); err != nil {
+		return decodeError(err)
+	}
+	return nil
+}
+
+// hasJSONContentType reports whether r's Content-Type is application/json or a +json type.
+func hasJSONContentType(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// decodeError converts an error from encoding/json into a typed error which is safe to
+// return to callers, keeping the original error as the cause.
+func decodeError(err error) error {
+	invalid := apierrors.Wrap(err, apierrors.CodeInvalidArgument, "Invalid JSON request body")
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return invalid.WithFields(apierrors.FieldError{Reason: fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)})
+	case errors.As(err, &typeErr):
+		return invalid.WithFields(apierrors.FieldError{Field: typeErr.Field, Reason: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)})
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return invalid.WithFields(apierrors.FieldError{Reason: "unexpected end of JSON"})
+	}
+	return invalid
+}
+
+// shapeChecker walks the tokens of a JSON document alongside the Go type it will be
+// decoded into, collecting every field which can not be decoded rather than stopping
+// at the first as encoding/json does.
+type shapeChecker struct {
+	decoder *json.Decoder
+	options DecodeOptions
+	fields  []apierrors.FieldError
+}
+
+// checkJSONShape checks the JSON document body against the type it will be decoded into,
+// returning a typed error listing every offending field (if any).
+func checkJSONShape(body []byte, t reflect.Type, options DecodeOptions) error {
+	checker := shapeChecker{
+		decoder: json.NewDecoder(bytes.NewReader(body)),
+		options: options,
+	}
+	checker.decoder.UseNumber()
+	err := checker.value(t, "", 0)
+	if err == nil && options.DisallowTrailingData {
+		if _, trailingErr := checker.decoder.Token(); trailingErr != io.EOF {
+			checker.fail("", "unexpected data after JSON value")
+		}
+	}
+	if err != nil && err != errMaxDepth {
+		return decodeError(err)
+	}
+	if len(checker.fields) > 0 {
+		return apierrors.InvalidArgument("Invalid JSON request body").WithFields(checker.fields...)
+	}
+	return nil
+}
+
+// fail records a problem with the field at path.
+func (c *shapeChecker) fail(path string, reason string) {
+	c.fields = append(c.fields, apierrors.FieldError{Field: path, Reason: reason})
+}
+
+// value checks the next JSON value against t, which is nil when any value is acceptable.
+func (c *shapeChecker) value(t reflect.Type, path string, depth int) error {
+	token, err := c.decoder.Token()
+	if err != nil {
+		return err
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && (t.Kind() == reflect.Interface || decodesItself(t)) {
+		t = nil
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		if t != nil && token != nil {
+			if reason := scalarMismatch(t, token); reason != "" {
+				c.fail(path, reason)
+			}
+		}
+		return nil
+	}
+	depth++
+	if c.options.MaxDepth > 0 && depth > c.options.MaxDepth {
+		c.fail(path, fmt.Sprintf("nesting exceeds maximum depth of %d", c.options.MaxDepth))
+		return errMaxDepth
+	}
+	if delim == '[' {
+		return c.array(t, path, depth)
+	}
+	return c.object(t, path, depth)
+}
+
+// array checks the elements of an array whose opening delimiter has been read.
+func (c *shapeChecker) array(t reflect.Type, path string, depth int) error {
+	var elem reflect.Type
+	if t != nil {
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			elem = t.Elem()
+		} else {
+			c.fail(path, "expected "+describeType(t)+", got array")
+		}
+	}
+	for index := 0; c.decoder.More(); index++ {
+		if err := c.value(elem, fmt.Sprintf("%s[%d]", path, index), depth); err != nil {
+			return err
+		}
+	}
+	_, err := c.decoder.Token()
+	return err
+}
+
+// object checks the members of an object whose opening delimiter has been read.
+func (c *shapeChecker) object(t reflect.Type, path string, depth int) error {
+	var fields []jsonField
+	if t != nil {
+		switch t.Kind() {
+		case reflect.Struct:
+			fields = structFields(t)
+		case reflect.Map:
+		default:
+			c.fail(path, "expected "+describeType(t)+", got object")
+			t = nil
+		}
+	}
+	for c.decoder.More() {
+		token, err := c.decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := token.(string)
+		fieldPath := key
+		if path != "" {
+			fieldPath = path + "." + key
+		}
+		var fieldType reflect.Type
+		switch {
+		case t == nil:
+		case t.Kind() == reflect.Map:
+			fieldType = t.Elem()
+		default:
+			var known bool
+			fieldType, known = lookupField(fields, key)
+			if !known && c.options.DisallowUnknownFields {
+				c.fail(fieldPath, "unknown field")
+			}
+		}
+		if err := c.value(fieldType, fieldPath, depth); err != nil {
+			return err
+		}
+	}
+	_, err := c.decoder.Token()
+	return err
+}
+
+// decodesItself reports whether values of t are decoded by their own UnmarshalJSON or UnmarshalText method.
+func decodesItself(t reflect.Type) bool {
+	pointer := reflect.PtrTo(t)
+	return pointer.Implements(jsonUnmarshalerType) || pointer.Implements(textUnmarshalerType)
+}
+
+// scalarMismatch returns the reason a JSON string, number or boolean can not be decoded into t, if any.
+func scalarMismatch(t reflect.Type, token json.Token) string {
+	switch value := token.(type) {
+	case string:
+		if t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8) {
+			return ""
+		}
+		return "expected " + describeType(t) + ", got string"
+	case bool:
+		if t.Kind() == reflect.Bool {
+			return ""
+		}
+		return "expected " + describeType(t) + ", got boolean"
+	case json.Number:
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return ""
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if strings.ContainsAny(string(value), ".eE") {
+				return "expected integer, got " + string(value)
+			}
+			return ""
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if strings.ContainsAny(string(value), ".eE-") {
+				return "expected non-negative integer, got " + string(value)
+			}
+			return ""
+		}
+		return "expected " + describeType(t) + ", got number"
+	}
+	return ""
+}
+
+// describeType names the kind of JSON value t is decoded from.
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return t.String()
+}
+
+// jsonField is a field of a struct as seen by encoding/json.
+type jsonField struct {
+	name      string
+	fieldType reflect.Type // Type decoded into, nil when any value is accepted
+	depth     int          // Depth of embedding, zero for fields declared directly in the struct
+	tagged    bool         // Whether the name came from a json tag
+}
+
+// structFields returns the JSON fields of struct type t in field order, following the
+// encoding/json rules for tags and embedded structs: a field is hidden by a field with
+// the same name embedded less deeply, and fields with the same name at the same depth
+// hide each other unless exactly one of them is tagged.
+func structFields(t reflect.Type) []jsonField {
+	var candidates []jsonField
+	collectFields(t, 0, map[reflect.Type]bool{}, &candidates)
+	byName := map[string][]jsonField{}
+	for _, field := range candidates {
+		byName[field.name] = append(byName[field.name], field)
+	}
+	var fields []jsonField
+	for _, field := range candidates {
+		if dominant, ok := dominantField(byName[field.name]); ok && dominant == field {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// collectFields appends every field of struct type t, including those of embedded
+// structs, in field order. visiting holds the embedded types being walked, to stop cycles.
+func collectFields(t reflect.Type, depth int, visiting map[reflect.Type]bool, fields *[]jsonField) {
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		tagParts := strings.Split(tag, ",")
+		name := tagParts[0]
+		fieldType := field.Type
+		if field.Anonymous {
+			embeddedType := fieldType
+			if embeddedType.Kind() == reflect.Ptr {
+				embeddedType = embeddedType.Elem()
+			}
+			if !field.IsExported() && embeddedType.Kind() != reflect.Struct {
+				continue
+			}
+			if name == "" && embeddedType.Kind() == reflect.Struct {
+				collectFields(embeddedType, depth+1, visiting, fields)
+				continue
+			}
+		} else if !field.IsExported() {
+			continue
+		}
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+		for _, option := range tagParts[1:] {
+			if option == "string" {
+				// Values are quoted, so leave checking them to encoding/json
+				fieldType = nil
+			}
+		}
+		*fields = append(*fields, jsonField{name: name, fieldType: fieldType, depth: depth, tagged: tagged})
+	}
+}
+
+// dominantField returns the field which encoding/json decodes a name into from the
+// fields sharing that name, or false if the name is ambiguous.
+func dominantField(fields []jsonField) (jsonField, bool) {
+	var shallowest []jsonField
+	for _, field := range fields {
+		switch {
+		case len(shallowest) == 0 || field.depth < shallowest[0].depth:
+			shallowest = []jsonField{field}
+		case field.depth == shallowest[0].depth:
+			shallowest = append(shallowest, field)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0], true
+	}
+	var tagged []jsonField
+	for _, field := range shallowest {
+		if field.tagged {
+			tagged = append(tagged, field)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return jsonField{}, false
+}
+
+// lookupField finds the field for an object key, preferring an exact match and
+// otherwise the first field matching case-insensitively, as encoding/json does.
+func lookupField(fields []jsonField, key string) (reflect.Type, bool) {
+	for _, field := range fields {
+		if field.name == key {
+			return field.fieldType, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, key) {
+			return field.fieldType, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "github.com/tozny/utils-go/errors"
+)
+
+type decodeBase struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type decodeColor struct {
+	Color bool
+}
+
+type decodeOther struct {
+	Color string
+	Size  int `json:"size"`
+}
+
+type decodeDeep struct {
+	decodeColor
+}
+
+type decodeTagged struct {
+	Name bool `json:"name"`
+}
+
+type decodeUntagged struct {
+	Name int
+}
+
+type decodeEmbedded struct {
+	decodeBase
+	Extra string `json:"extra"`
+}
+
+type decodePointerEmbedded struct {
+	*decodeBase
+}
+
+type decodeShadowed struct {
+	decodeBase
+	Name []string `json:"name"`
+}
+
+type decodeAmbiguous struct {
+	decodeColor
+	decodeOther
+}
+
+type decodeShallowerWins struct {
+	decodeDeep
+	decodeOther
+}
+
+type decodeTaggedWins struct {
+	decodeTagged
+	decodeUntagged
+}
+
+type decodeNamedEmbedded struct {
+	decodeBase `json:"base"`
+}
+
+type decodeOptions struct {
+	Count   int64     `json:"count,string"`
+	Skipped string    `json:"-"`
+	Dash    string    `json:"-,"`
+	When    time.Time `json:"when"`
+	Any     interface{}
+	Raw     json.RawMessage
+	Labels  map[string]int
+	Items   []decodeBase
+	private string
+}
+
+// decodeWithJSON reports whether encoding/json decodes body into a new value of t with unknown fields disallowed.
+func decodeWithJSON(t reflect.Type, body string) error {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(reflect.New(t).Interface())
+}
+
+// decodeWithOptions decodes body into a new value of t with UnmarshalJSONRequestWithOptions.
+func decodeWithOptions(t reflect.Type, body string, contentType string, options DecodeOptions) (interface{}, error) {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	value := reflect.New(t).Interface()
+	return value, UnmarshalJSONRequestWithOptions(request, value, options)
+}
+
+func TestUnmarshalJSONRequestWithOptionsMatchesEncodingJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		t    reflect.Type
+		body string
+	}{
+		{"embedded fields", reflect.TypeOf(decodeEmbedded{}), `{"id":1,"name":"n","extra":"e"}`},
+		{"embedded field type", reflect.TypeOf(decodeEmbedded{}), `{"id":"1"}`},
+		{"pointer embedded", reflect.TypeOf(decodePointerEmbedded{}), `{"id":1,"name":"n"}`},
+		{"shadowed field", reflect.TypeOf(decodeShadowed{}), `{"id":1,"name":["a","b"]}`},
+		{"shadowed field type", reflect.TypeOf(decodeShadowed{}), `{"name":"n"}`},
+		{"ambiguous field", reflect.TypeOf(decodeAmbiguous{}), `{"Color":"red"}`},
+		{"ambiguous field other fields", reflect.TypeOf(decodeAmbiguous{}), `{"size":1}`},
+		{"shallower field wins", reflect.TypeOf(decodeShallowerWins{}), `{"color":"red"}`},
+		{"shallower field wins type", reflect.TypeOf(decodeShallowerWins{}), `{"Color":true}`},
+		{"tagged field wins", reflect.TypeOf(decodeTaggedWins{}), `{"name":true}`},
+		{"tagged field wins type", reflect.TypeOf(decodeTaggedWins{}), `{"name":1}`},
+		{"named embedded", reflect.TypeOf(decodeNamedEmbedded{}), `{"base":{"id":1}}`},
+		{"named embedded not promoted", reflect.TypeOf(decodeNamedEmbedded{}), `{"id":1}`},
+		{"string option", reflect.TypeOf(decodeOptions{}), `{"count":"42"}`},
+		{"string option unquoted", reflect.TypeOf(decodeOptions{}), `{"count":42}`},
+		{"ignored field", reflect.TypeOf(decodeOptions{}), `{"Skipped":"x"}`},
+		{"dash name", reflect.TypeOf(decodeOptions{}), `{"-":"x"}`},
+		{"unexported field", reflect.TypeOf(decodeOptions{}), `{"private":"x"}`},
+		{"case insensitive keys", reflect.TypeOf(decodeOptions{}), `{"COUNT":"1","items":[{"ID":1,"NAME":"n"}],"labels":{"a":1}}`},
+		{"text unmarshaler", reflect.TypeOf(decodeOptions{}), `{"when":"2020-01-01T00:00:00Z"}`},
+		{"interface and raw values", reflect.TypeOf(decodeOptions{}), `{"Any":{"x":[1,"y"]},"Raw":[{"z":null}]}`},
+		{"map value type", reflect.TypeOf(decodeOptions{}), `{"Labels":{"a":"1"}}`},
+		{"array element type", reflect.TypeOf(decodeOptions{}), `{"Items":[{"id":1},{"id":1.5}]}`},
+		{"unknown nested field", reflect.TypeOf(decodeOptions{}), `{"Items":[{"id":1,"other":true}]}`},
+		{"null values", reflect.TypeOf(decodeOptions{}), `{"Items":null,"when":null,"count":null}`},
+		{"negative unsigned", reflect.TypeOf(struct{ N uint }{}), `{"N":-1}`},
+		{"exponent integer", reflect.TypeOf(struct{ N int }{}), `{"N":1e3}`},
+		{"bytes from string", reflect.TypeOf(struct{ B []byte }{}), `{"B":"aGk="}`},
+		{"object for scalar", reflect.TypeOf(struct{ S string }{}), `{"S":{}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jsonErr := decodeWithJSON(test.t, test.body)
+			_, err := decodeWithOptions(test.t, test.body, "application/json", StrictDecodeOptions)
+			if (jsonErr == nil) != (err == nil) {
+				t.Errorf("Expected the same outcome as encoding/json (error %v), got %v", jsonErr, err)
+			}
+			var typed *apierrors.Error
+			if err != nil && (!errors.As(err, &typed) || typed.Status != http.StatusBadRequest) {
+				t.Errorf("Expected a typed 400 error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONRequestWithOptionsReportsEveryField(t *testing.T) {
+	_, err := decodeWithOptions(reflect.TypeOf(decodeEmbedded{}), `{"id":"1","name":2,"unknown":true}`, "application/json", StrictDecodeOptions)
+	var typed *apierrors.Error
+	if !errors.As(err, &typed) {
+		t.Fatalf("Expected a typed error, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, field := range typed.Fields {
+		fields[field.Field] = true
+	}
+	if len(fields) != 3 || !fields["id"] || !fields["name"] || !fields["unknown"] {
+		t.Errorf("Expected every offending field to be reported, got %+v", typed.Fields)
+	}
+}
+
+func TestUnmarshalJSONRequestWithOptionsLimits(t *testing.T) {
+	type nested struct{ Any interface{} }
+	deep := `{"Any":` + strings.Repeat("[", DefaultMaxDepth) + strings.Repeat("]", DefaultMaxDepth) + `}`
+	shallow := `{"Any":` + strings.Repeat("[", DefaultMaxDepth-1) + strings.Repeat("]", DefaultMaxDepth-1) + `}`
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		options     DecodeOptions
+		status      int
+	}{
+		{"within depth", shallow, "application/json", StrictDecodeOptions, 0},
+		{"too deep", deep, "application/json", StrictDecodeOptions, http.StatusBadRequest},
+		{"no depth limit", deep, "application/json", DecodeOptions{}, 0},
+		{"trailing data", `{"Any":1} {"Any":2}`, "application/json", StrictDecodeOptions, http.StatusBadRequest},
+		{"trailing whitespace", "{\"Any\":1}\n", "application/json", StrictDecodeOptions, 0},
+		{"trailing data allowed", `{"Any":1} garbage`, "application/json", DecodeOptions{}, 0},
+		{"empty body", "", "application/json", StrictDecodeOptions, http.StatusBadRequest},
+		{"whitespace body", " \n", "application/json", StrictDecodeOptions, http.StatusBadRequest},
+		{"empty body allowed", "", "", DecodeOptions{AllowEmptyBody: true, RequireJSONContentType: true}, 0},
+		{"missing content type", `{"Any":1}`, "", StrictDecodeOptions, http.StatusUnsupportedMediaType},
+		{"text content type", `{"Any":1}`, "text/plain", StrictDecodeOptions, http.StatusUnsupportedMediaType},
+		{"json content type parameters", `{"Any":1}`, "application/json; charset=utf-8", StrictDecodeOptions, 0},
+		{"json suffix content type", `{"Any":1}`, "application/merge-patch+json", StrictDecodeOptions, 0},
+		{"content type not required", `{"Any":1}`, "text/plain", DecodeOptions{}, 0},
+		{"malformed", `{"Any":`, "application/json", StrictDecodeOptions, http.StatusBadRequest},
+		{"too large", `{"Any":"` + strings.Repeat("x", 64) + `"}`, "application/json", DecodeOptions{MaxBytes: 16}, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeWithOptions(reflect.TypeOf(nested{}), test.body, test.contentType, test.options)
+			status := 0
+			var typed *apierrors.Error
+			if errors.As(err, &typed) {
+				status = typed.Status
+			} else if err != nil {
+				t.Fatalf("Expected a typed error, got %v", err)
+			}
+			if status != test.status {
+				t.Errorf("Expected status %d, got %d (error %v)", test.status, status, err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONRequestWithOptionsDecodesValue(t *testing.T) {
+	value, err := decodeWithOptions(reflect.TypeOf(decodeShadowed{}), `{"ID":7,"name":["a"]}`, "application/json", StrictDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := value.(*decodeShadowed)
+	if decoded.ID != 7 || !reflect.DeepEqual(decoded.Name, []string{"a"}) || decoded.decodeBase.Name != "" {
+		t.Errorf("Unexpected decoded value %+v", decoded)
+	}
+	var buffer bytes.Buffer
+	json.NewEncoder(&buffer).Encode(decoded)
+	if strings.TrimSpace(buffer.String()) != `{"id":7,"name":["a"]}` {
+		t.Errorf("Unexpected round trip %s", buffer.String())
+	}
+}
@@ -4,16 +4,31 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+
+	apierrors "github.com/tozny/utils-go/errors"
 )
 
-// UnmarshalJSONRequest un-marshals a request object body JSON into the passed interface
+// UnmarshalJSONRequest un-marshals a request object body JSON into the passed interface,
+// reading at most DefaultMaxBodyBytes of the body. The returned errors render through
+// WriteError as a 413 if the body is too large and a 400 if it is unreadable or invalid.
+// Use UnmarshalJSONRequestWithOptions to reject unknown fields and malformed requests.
 func UnmarshalJSONRequest(r *http.Request, obj interface{}) error {
-	body, err := ioutil.ReadAll(r.Body)
+	return UnmarshalJSONRequestLimit(r, obj, DefaultMaxBodyBytes)
+}
+
+// UnmarshalJSONRequestLimit un-marshals a request object body JSON into the passed
+// interface, reading at most maxBytes of the body (no limit if maxBytes is negative).
+func UnmarshalJSONRequestLimit(r *http.Request, obj interface{}, maxBytes int64) error {
+	body := r.Body
+	if maxBytes >= 0 {
+		body = http.MaxBytesReader(nil, body, maxBytes)
+	}
+	bodyBytes, err := ioutil.ReadAll(body)
 	if err != nil {
-		return err
+		return bodyReadError(err)
 	}
-	if err := json.Unmarshal(body, obj); err != nil {
-		return err
+	if err := json.Unmarshal(bodyBytes, obj); err != nil {
+		return apierrors.Wrap(err, apierrors.CodeInvalidArgument, "Invalid JSON request body")
 	}
 	return nil
 }
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tozny/utils-go/breaker"
+	apierrors "github.com/tozny/utils-go/errors"
+	"github.com/tozny/utils-go/logging"
+	"github.com/tozny/utils-go/requestid"
+)
+
+// ErrorResponse is the JSON body written for every error by WriteError.
+type ErrorResponse struct {
+	Code      apierrors.Code         `json:"code"`
+	Message   string                 `json:"message"`
+	Fields    []apierrors.FieldError `json:"fields,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// WriteError responds to r with the JSON ErrorResponse and HTTP status for err,
+// including r's request ID (if any) so callers can quote it when reporting problems.
+// Errors from the errors package are rendered with their own code, status and public
+// message, the sentinel errors from this package and circuit breakers are mapped to
+// an equivalent typed error, and any other error is reported as an internal error
+// without exposing its text. The internal detail and cause are logged through
+// logger.Errorw if logger is not nil.
+func WriteError(w http.ResponseWriter, r *http.Request, logger logging.StructuredLogger, err error) {
+	if err == nil {
+		return
+	}
+	typed := typedError(err)
+	if logger != nil {
+		keysAndValues := []interface{}{"error-code", typed.Code, "status", typed.Status}
+		if typed.Detail != "" {
+			keysAndValues = append(keysAndValues, "detail", typed.Detail)
+		}
+		if typed.Cause != nil {
+			keysAndValues = append(keysAndValues, "cause", typed.Cause.Error())
+		}
+		logger.Errorw(typed.Message, r, keysAndValues...)
+	}
+	response := ErrorResponse{
+		Code:    typed.Code,
+		Message: typed.Message,
+		Fields:  typed.Fields,
+	}
+	if r != nil {
+		response.RequestID = requestid.FromRequest(r)
+	}
+	HandleError(w, typed.Status, response)
+}
+
+// typedError maps err to an *apierrors.Error, translating the sentinel errors
+// returned by this package and its dependencies.
+func typedError(err error) *apierrors.Error {
+	var typed *apierrors.Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return bodyReadError(err)
+	}
+	// Only the static sentinel message is returned, as wrapped authentication errors
+	// may describe why a credential was rejected
+	for _, sentinel := range []error{ErrorInvalidAuthorizationHeader, ErrorUnsupportedAuthorizationType, ErrorInvalidAuthToken, ErrorInvalidAuthentication} {
+		if errors.Is(err, sentinel) {
+			return apierrors.Wrap(err, apierrors.CodeUnauthenticated, sentinel.Error())
+		}
+	}
+	switch {
+	case errors.Is(err, ErrorAuthenticationUnavailable):
+		return apierrors.Unavailable(err, ErrorAuthenticationUnavailable.Error())
+	case errors.Is(err, breaker.ErrOpen), errors.Is(err, breaker.ErrTooManyRequests):
+		return apierrors.Unavailable(err, "Service temporarily unavailable")
+	}
+	return apierrors.From(err)
+}
@@ -1,8 +1,13 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/tozny/utils-go/breaker"
+	"github.com/tozny/utils-go/health"
+	"github.com/tozny/utils-go/logging"
 )
 
 // HandleOptionsRequest is a generic handler for responding 200 OK for an HTTP Options request.
@@ -18,3 +23,46 @@ func HealthCheckHandler(serviceName string) http.Handler {
 		w.Write([]byte(fmt.Sprintf("%s service is up.\n", serviceName)))
 	})
 }
+
+// ServiceCheckHandler runs the checks in registry and reports their status and latency as
+// a JSON health.Report, returning 200 if every check passed, otherwise 503. Mount it on a path
+// ending in ServiceCheckPathSuffix so that authentication middleware exempts it. As the
+// response is unauthenticated, check errors are only logged via logger (if not nil).
+func ServiceCheckHandler(registry *health.Registry, logger logging.StructuredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := registry.Check(r.Context())
+		statusCode := http.StatusOK
+		if !report.Healthy() {
+			statusCode = http.StatusServiceUnavailable
+		}
+		if logger != nil {
+			for _, result := range report.Checks {
+				if result.Error != "" {
+					logger.Errorw("ServiceCheckHandler: check failed", r, "check", result.Name, "error", result.Error)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(report.WithoutErrors())
+	})
+}
+
+// CircuitBreakerStatusHandler reports the status of the provided circuit breakers as JSON,
+// returning 200 if none are open, otherwise 503
+func CircuitBreakerStatusHandler(breakers ...*breaker.Breaker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusCode := http.StatusOK
+		statuses := make([]breaker.Status, 0, len(breakers))
+		for _, circuitBreaker := range breakers {
+			status := circuitBreaker.Status()
+			if status.State == breaker.Open.String() {
+				statusCode = http.StatusServiceUnavailable
+			}
+			statuses = append(statuses, status)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tozny/utils-go/health"
+)
+
+func TestServiceCheckHandlerHidesCheckErrors(t *testing.T) {
+	registry := health.NewRegistry(health.Config{})
+	registry.Register("database", func(ctx context.Context) error {
+		return errors.New("dial tcp db.internal:5432: connection refused")
+	})
+	registry.Register("cache", func(ctx context.Context) error { return nil })
+	logger := &testLogger{}
+	recorder := httptest.NewRecorder()
+	ServiceCheckHandler(registry, logger).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/things/servicecheck", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", recorder.Code)
+	}
+	if strings.Contains(recorder.Body.String(), "db.internal") {
+		t.Errorf("Expected check errors not to be exposed, got %s", recorder.Body)
+	}
+	var report health.Report
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil || len(report.Checks) != 2 || report.Checks[1].Status != health.StatusFail {
+		t.Errorf("Expected the status of each check, got %+v (error %v)", report, err)
+	}
+	if !strings.Contains(logger.String(), "db.internal") {
+		t.Errorf("Expected check errors to be logged, got\n%s", logger)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	apierrors "github.com/tozny/utils-go/errors"
+	"github.com/tozny/utils-go/logging"
+)
+
+// Validator is implemented by request types which validate their own fields, returning
+// an error (if any). Return a typed error, e.g. apierrors.InvalidArgument with FieldErrors,
+// to tell callers what is wrong. Other errors are reported to callers as a generic invalid
+// argument, and their text is only logged. Validate implementations are expected to use
+// the predicates in the validation package (e.g. validation.IsValidKey) for individual
+// values. That package has no struct level validation for JSON to call instead.
+type Validator interface {
+	Validate() error
+}
+
+// JSONOption configures a handler created by JSON.
+type JSONOption func(*jsonOptions)
+
+// jsonOptions holds the configurable behavior of handlers created by JSON.
+type jsonOptions struct {
+	status     int
+	logger     logging.StructuredLogger
+	maxBytes   int64
+	allowEmpty bool
+}
+
+// WithStatus sets the status code written for successful responses, by default 200.
+// Responses with status 204 No Content are written without a body.
+func WithStatus(statusCode int) JSONOption {
+	return func(o *jsonOptions) {
+		o.status = statusCode
+	}
+}
+
+// WithLogger sets the logger errors are logged to through WriteError.
+func WithLogger(logger logging.StructuredLogger) JSONOption {
+	return func(o *jsonOptions) {
+		o.logger = logger
+	}
+}
+
+// WithMaxBodyBytes sets the largest request body accepted, by default DefaultMaxBodyBytes.
+func WithMaxBodyBytes(maxBytes int64) JSONOption {
+	return func(o *jsonOptions) {
+		o.maxBytes = maxBytes
+	}
+}
+
+// AllowEmptyBody passes the zero value request to the handler when the request has no body,
+// which by default is only allowed for GET, HEAD and DELETE requests.
+func AllowEmptyBody() JSONOption {
+	return func(o *jsonOptions) {
+		o.allowEmpty = true
+	}
+}
+
+// JSON adapts a typed handler function to an http.Handler. The request body is decoded
+// into a Req with StrictDecodeOptions, validated if Req implements
+// Validator, and passed to handler with the request's context, from which the Principal
+// authenticated by RequestAuthMiddleware is available via PrincipalFrom. The
+// returned Resp is written as JSON with the configured success status. Errors from any
+// step are written with WriteError, so typed errors map to their status and message.
+func JSON[Req any, Resp any](handler func(ctx context.Context, request Req) (Resp, error), options ...JSONOption) http.Handler {
+	config := jsonOptions{
+		status:   http.StatusOK,
+		maxBytes: DefaultMaxBodyBytes,
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request Req
+		if err := decodeJSONRequest(r, &request, config); err != nil {
+			WriteError(w, r, config.logger, err)
+			return
+		}
+		if err := validateRequest(request, &request); err != nil {
+			WriteError(w, r, config.logger, err)
+			return
+		}
+		response, err := handler(r.Context(), request)
+		if err != nil {
+			WriteError(w, r, config.logger, err)
+			return
+		}
+		if config.status == http.StatusNoContent {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(config.status)
+		if err := json.NewEncoder(w).Encode(response); err != nil && config.logger != nil {
+			config.logger.Errorw("JSON: error encoding response", r, "error", err.Error())
+		}
+	})
+}
+
+// decodeJSONRequest decodes r's body into request with StrictDecodeOptions, allowing an
+// empty body only for methods which do not usually have one or when configured to.
+func decodeJSONRequest(r *http.Request, request interface{}, config jsonOptions) error {
+	options := StrictDecodeOptions
+	options.MaxBytes = config.maxBytes
+	options.AllowEmptyBody = config.allowEmpty || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodDelete
+	return UnmarshalJSONRequestWithOptions(r, request, options)
+}
+
+// validateRequest validates request if it or pointer, which points to request, implements
+// Validator, so that Validate is found with either a pointer or value receiver whether or
+// not the request type is itself a pointer. Nil pointer requests, decoded from a null body,
+// are not validated. Untyped errors are wrapped with a fixed message, as they may describe
+// internals of the service.
+func validateRequest(request interface{}, pointer interface{}) error {
+	if value := reflect.ValueOf(request); value.Kind() == reflect.Ptr && value.IsNil() {
+		return nil
+	}
+	validator, ok := request.(Validator)
+	if !ok {
+		validator, ok = pointer.(Validator)
+	}
+	if !ok {
+		return nil
+	}
+	err := validator.Validate()
+	if err == nil {
+		return nil
+	}
+	var typed *apierrors.Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	return apierrors.Wrap(err, apierrors.CodeInvalidArgument, "Invalid request")
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apierrors "github.com/tozny/utils-go/errors"
+)
+
+type createThingRequest struct {
+	Name string `json:"name"`
+}
+
+func (r createThingRequest) Validate() error {
+	switch r.Name {
+	case "":
+		return apierrors.InvalidArgument("Invalid request").WithFields(apierrors.FieldError{Field: "name", Reason: "is required"})
+	case "internal":
+		return errors.New("name collides with table things_internal")
+	}
+	return nil
+}
+
+type thingResponse struct {
+	Name     string `json:"name"`
+	ClientID string `json:"client_id"`
+}
+
+// serveJSON serves a request with body through handler, returning the recorded response.
+func serveJSON(handler http.Handler, method string, body string) *httptest.ResponseRecorder {
+	var request *http.Request
+	if body == "" {
+		request = httptest.NewRequest(method, "/v1/things", nil)
+	} else {
+		request = httptest.NewRequest(method, "/v1/things", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request = request.WithContext(ContextWithClientID(request.Context(), "client-1"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func createThing(ctx context.Context, request createThingRequest) (thingResponse, error) {
+	if request.Name == "missing" {
+		return thingResponse{}, apierrors.NotFound("Thing not found")
+	}
+	clientID, _ := ClientIDFromContext(ctx)
+	return thingResponse{Name: request.Name, ClientID: clientID}, nil
+}
+
+func TestJSONWritesResponseWithStatus(t *testing.T) {
+	recorder := serveJSON(JSON(createThing, WithStatus(http.StatusCreated)), http.MethodPost, `{"name":"widget"}`)
+	if recorder.Code != http.StatusCreated || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON 201, got %d and %v", recorder.Code, recorder.Header())
+	}
+	if body := strings.TrimSpace(recorder.Body.String()); body != `{"name":"widget","client_id":"client-1"}` {
+		t.Errorf("Unexpected response body %s", body)
+	}
+	recorder = serveJSON(JSON(createThing, WithStatus(http.StatusNoContent)), http.MethodPost, `{"name":"widget"}`)
+	if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204, got %d with %q", recorder.Code, recorder.Body)
+	}
+}
+
+func TestJSONEmptyBody(t *testing.T) {
+	list := func(ctx context.Context, request struct{}) ([]string, error) { return []string{"widget"}, nil }
+	if recorder := serveJSON(JSON(list), http.MethodGet, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected an empty GET body to be allowed, got %d", recorder.Code)
+	}
+	if recorder := serveJSON(JSON(list), http.MethodPost, ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected an empty POST body to be rejected, got %d", recorder.Code)
+	}
+	if recorder := serveJSON(JSON(list, AllowEmptyBody()), http.MethodPost, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected AllowEmptyBody to allow an empty POST body, got %d", recorder.Code)
+	}
+}
+
+func TestJSONReportsErrors(t *testing.T) {
+	logger := &testLogger{}
+	handler := JSON(createThing, WithLogger(logger))
+	recorder := serveJSON(handler, http.MethodPost, `{"name":""}`)
+	response := decodeErrorResponse(t, recorder)
+	if recorder.Code != http.StatusBadRequest || len(response.Fields) != 1 || response.Fields[0].Field != "name" {
+		t.Errorf("Expected typed validation errors with fields, got %d and %+v", recorder.Code, response)
+	}
+	recorder = serveJSON(handler, http.MethodPost, `{"name":"internal"}`)
+	response = decodeErrorResponse(t, recorder)
+	if recorder.Code != http.StatusBadRequest || response.Code != apierrors.CodeInvalidArgument || response.Message != "Invalid request" {
+		t.Errorf("Expected a generic invalid argument for untyped validation errors, got %d and %+v", recorder.Code, response)
+	}
+	if !strings.Contains(logger.String(), "things_internal") {
+		t.Errorf("Expected the untyped validation error to be logged, got\n%s", logger)
+	}
+	if recorder = serveJSON(handler, http.MethodPost, `{"name":"missing"}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected handler typed errors to set the status, got %d", recorder.Code)
+	}
+	if recorder = serveJSON(JSON(createThing, WithMaxBodyBytes(8)), http.MethodPost, `{"name":"widget"}`); recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the body limit to be enforced, got %d", recorder.Code)
+	}
+}
+
+// pointerThingRequest is a request validated through a pointer receiver.
+type pointerThingRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *pointerThingRequest) Validate() error {
+	if r.Name == "" {
+		return apierrors.InvalidArgument("Invalid request").WithFields(apierrors.FieldError{Field: "name", Reason: "is required"})
+	}
+	return nil
+}
+
+func TestJSONValidatesPointerRequests(t *testing.T) {
+	createThingByPointer := func(ctx context.Context, request *createThingRequest) (thingResponse, error) {
+		if request == nil {
+			return thingResponse{}, apierrors.InvalidArgument("Missing request")
+		}
+		return createThing(ctx, *request)
+	}
+	tests := []struct {
+		name    string
+		handler http.Handler
+		body    string
+		status  int
+	}{
+		{"pointer request value receiver", JSON(createThingByPointer), `{"name":""}`, http.StatusBadRequest},
+		{"pointer request valid", JSON(createThingByPointer), `{"name":"widget"}`, http.StatusOK},
+		{"pointer request null", JSON(createThingByPointer), `null`, http.StatusBadRequest},
+		{"pointer receiver", JSON(func(ctx context.Context, request pointerThingRequest) (thingResponse, error) {
+			return thingResponse{Name: request.Name}, nil
+		}), `{"name":""}`, http.StatusBadRequest},
+		{"pointer request pointer receiver", JSON(func(ctx context.Context, request *pointerThingRequest) (thingResponse, error) {
+			return thingResponse{Name: request.Name}, nil
+		}), `{"name":""}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if recorder := serveJSON(test.handler, http.MethodPost, test.body); recorder.Code != test.status {
+				t.Errorf("Expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
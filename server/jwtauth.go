@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/tozny/utils-go/auth"
+	"github.com/tozny/utils-go/clock"
+)
+
+const (
+	// AuthMethodJWT principals presented a bearer JWT verified locally by a JWTAuthenticator
+	AuthMethodJWT AuthMethod = "jwt"
+	// DefaultJWTLeeway is the clock skew allowed when checking a JWT's exp and nbf claims
+	DefaultJWTLeeway = 30 * time.Second
+	// DefaultJWTClientIDClaim is the claim a JWT's client ID is read from
+	DefaultJWTClientIDClaim = "sub"
+)
+
+var (
+	// ErrInvalidJWTAuthConfig is returned when a JWTAuthenticator is configured without any public keys
+	ErrInvalidJWTAuthConfig = errors.New("invalid JWT authenticator config")
+)
+
+// JWTAuthConfig wraps configuration for a JWTAuthenticator.
+type JWTAuthConfig struct {
+	PublicKeys     []*rsa.PublicKey // Keys tokens may be signed with, e.g. the public half of an auth.TokenFactory's SigningKey
+	Issuers        []string         // Accepted iss claims, empty accepts any issuer
+	Audiences      []string         // Tokens must list at least one of these in their aud claim, empty accepts any audience
+	RequiredClaims []string         // Names of claims every token must carry
+	Leeway         time.Duration    // Clock skew allowed for exp and nbf, zero uses DefaultJWTLeeway and negative for none
+	ClientIDClaim  string           // String claim holding the client ID, empty uses DefaultJWTClientIDClaim
+	Clock          clock.Clock      // Source of time for exp and nbf, nil uses the system clock
+	// AllowMissingExpiry accepts tokens without an exp claim, which never expire (e.g. from
+	// auth.TokenFactory.Sign with a validTime of 0). By default such tokens are rejected.
+	AllowMissingExpiry bool
+}
+
+// JWTAuthenticator is a PrincipalAuthenticator which verifies bearer JWTs signed by an
+// auth.TokenFactory locally, without calling out to an authentication service.
+type JWTAuthenticator struct {
+	config JWTAuthConfig
+	keys   jwt.KeyRegister
+}
+
+// NewJWTAuthenticator returns a new JWTAuthenticator configured with the provided config,
+// or ErrInvalidJWTAuthConfig if no public keys are configured.
+func NewJWTAuthenticator(config JWTAuthConfig) (*JWTAuthenticator, error) {
+	if len(config.PublicKeys) == 0 {
+		return nil, fmt.Errorf("%w: no public keys", ErrInvalidJWTAuthConfig)
+	}
+	if config.Leeway == 0 {
+		config.Leeway = DefaultJWTLeeway
+	}
+	if config.Leeway < 0 {
+		config.Leeway = 0
+	}
+	if config.ClientIDClaim == "" {
+		config.ClientIDClaim = DefaultJWTClientIDClaim
+	}
+	config.Clock = clock.OrNew(config.Clock)
+	return &JWTAuthenticator{
+		config: config,
+		keys:   jwt.KeyRegister{RSAs: config.PublicKeys},
+	}, nil
+}
+
+// AuthenticateRequest authenticates r's bearer JWT, returning the client ID it was issued to and error (if any).
+func (a *JWTAuthenticator) AuthenticateRequest(ctx context.Context, r *http.Request) (string, error) {
+	principal, err := a.AuthenticatePrincipal(ctx, r)
+	return principal.ClientID, err
+}
+
+// AuthenticatePrincipal authenticates r's bearer JWT, returning a principal whose Claims
+// hold every claim in the token and whose JWT holds the parsed auth.Claims. Rejected
+// tokens return an error wrapping ErrorInvalidAuthToken describing why for logging.
+func (a *JWTAuthenticator) AuthenticatePrincipal(ctx context.Context, r *http.Request) (Principal, error) {
+	token, err := ExtractBearerToken(r)
+	if err != nil {
+		return Principal{}, err
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	var set map[string]interface{}
+	if err := json.Unmarshal(claims.Raw, &set); err != nil {
+		return Principal{}, fmt.Errorf("%w: %s", ErrorInvalidAuthToken, err)
+	}
+	clientID, _ := set[a.config.ClientIDClaim].(string)
+	if clientID == "" {
+		return Principal{}, fmt.Errorf("%w: missing %s claim", ErrorInvalidAuthToken, a.config.ClientIDClaim)
+	}
+	return Principal{
+		ClientID: clientID,
+		Token:    token,
+		Claims:   set,
+		JWT:      claims,
+		Method:   AuthMethodJWT,
+	}, nil
+}
+
+// Verify checks token's signature against the configured public keys and its exp, nbf,
+// iss, aud and required claims, returning the parsed claims and error (if any). Tokens
+// without an exp claim are rejected unless AllowMissingExpiry is configured.
+func (a *JWTAuthenticator) Verify(token string) (*auth.Claims, error) {
+	claims, err := a.keys.Check([]byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidAuthToken, err)
+	}
+	if claims.Expires == nil && !a.config.AllowMissingExpiry {
+		return nil, fmt.Errorf("%w: missing exp claim", ErrorInvalidAuthToken)
+	}
+	now := a.config.Clock.Now()
+	if claims.Expires != nil && !now.Add(-a.config.Leeway).Before(claims.Expires.Time()) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrorInvalidAuthToken, claims.Expires.Time())
+	}
+	if claims.NotBefore != nil && now.Add(a.config.Leeway).Before(claims.NotBefore.Time()) {
+		return nil, fmt.Errorf("%w: not valid before %s", ErrorInvalidAuthToken, claims.NotBefore.Time())
+	}
+	if len(a.config.Issuers) > 0 && !containsAny(a.config.Issuers, claims.Issuer) {
+		return nil, fmt.Errorf("%w: issuer %q not accepted", ErrorInvalidAuthToken, claims.Issuer)
+	}
+	if len(a.config.Audiences) > 0 && !containsAny(a.config.Audiences, claims.Audiences...) {
+		return nil, fmt.Errorf("%w: audiences %q not accepted", ErrorInvalidAuthToken, claims.Audiences)
+	}
+	if len(a.config.RequiredClaims) > 0 {
+		// Registered claims are not kept in claims.Set, so check the raw claims
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(claims.Raw, &present); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrorInvalidAuthToken, err)
+		}
+		for _, name := range a.config.RequiredClaims {
+			if _, ok := present[name]; !ok {
+				return nil, fmt.Errorf("%w: missing required claim %s", ErrorInvalidAuthToken, name)
+			}
+		}
+	}
+	return claims, nil
+}
+
+// containsAny reports whether any of values is in allowed.
+func containsAny(allowed []string, values ...string) bool {
+	for _, value := range values {
+		for _, candidate := range allowed {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"github.com/tozny/utils-go/auth"
+	"github.com/tozny/utils-go/clock"
+)
+
+var (
+	signingKeys     []*rsa.PrivateKey
+	signingKeysOnce sync.Once
+)
+
+// testSigningKeys returns two RSA keys, generated once as generating keys is slow.
+func testSigningKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey) {
+	t.Helper()
+	signingKeysOnce.Do(func() {
+		for i := 0; i < 2; i++ {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signingKeys = append(signingKeys, key)
+		}
+	})
+	return signingKeys[0], signingKeys[1]
+}
+
+func TestJWTAuthenticatorVerify(t *testing.T) {
+	key, otherKey := testSigningKeys(t)
+	start := time.Now().Round(time.Second)
+	tests := []struct {
+		name      string
+		config    JWTAuthConfig
+		claims    auth.Claims
+		validTime time.Duration
+		otherKey  bool
+		advance   time.Duration
+		valid     bool
+	}{
+		{"valid", JWTAuthConfig{}, auth.Claims{}, time.Minute, false, 0, true},
+		{"signed with another key", JWTAuthConfig{}, auth.Claims{}, time.Minute, true, 0, false},
+		{"expired within leeway", JWTAuthConfig{}, auth.Claims{}, time.Minute, false, time.Minute + 29*time.Second, true},
+		{"expired past leeway", JWTAuthConfig{}, auth.Claims{}, time.Minute, false, time.Minute + 30*time.Second, false},
+		{"expired with configured leeway", JWTAuthConfig{Leeway: 2 * time.Minute}, auth.Claims{}, time.Minute, false, 2 * time.Minute, true},
+		{"expired without leeway", JWTAuthConfig{Leeway: -1}, auth.Claims{}, time.Minute, false, time.Minute, false},
+		{"missing exp", JWTAuthConfig{}, auth.Claims{}, 0, false, 0, false},
+		{"missing exp with large leeway", JWTAuthConfig{Leeway: 24 * time.Hour}, auth.Claims{}, 0, false, 0, false},
+		{"missing exp allowed", JWTAuthConfig{AllowMissingExpiry: true}, auth.Claims{}, 0, false, 0, true},
+		{"not yet valid within leeway", JWTAuthConfig{}, auth.Claims{Registered: jwt.Registered{NotBefore: jwt.NewNumericTime(start.Add(30 * time.Second))}}, time.Minute, false, 0, true},
+		{"not yet valid past leeway", JWTAuthConfig{}, auth.Claims{Registered: jwt.Registered{NotBefore: jwt.NewNumericTime(start.Add(31 * time.Second))}}, time.Minute, false, 0, false},
+		{"accepted issuer", JWTAuthConfig{Issuers: []string{"other", "tozny"}}, auth.Claims{Registered: jwt.Registered{Issuer: "tozny"}}, time.Minute, false, 0, true},
+		{"rejected issuer", JWTAuthConfig{Issuers: []string{"tozny"}}, auth.Claims{Registered: jwt.Registered{Issuer: "attacker"}}, time.Minute, false, 0, false},
+		{"missing issuer", JWTAuthConfig{Issuers: []string{"tozny"}}, auth.Claims{}, time.Minute, false, 0, false},
+		{"accepted audience", JWTAuthConfig{Audiences: []string{"things"}}, auth.Claims{Registered: jwt.Registered{Audiences: []string{"other", "things"}}}, time.Minute, false, 0, true},
+		{"rejected audience", JWTAuthConfig{Audiences: []string{"things"}}, auth.Claims{Registered: jwt.Registered{Audiences: []string{"other"}}}, time.Minute, false, 0, false},
+		{"missing audience", JWTAuthConfig{Audiences: []string{"things"}}, auth.Claims{}, time.Minute, false, 0, false},
+		{"required claims present", JWTAuthConfig{RequiredClaims: []string{"scope", "jti"}}, auth.Claims{Registered: jwt.Registered{ID: "token-1"}, Set: map[string]interface{}{"scope": "read"}}, time.Minute, false, 0, true},
+		{"required claim missing", JWTAuthConfig{RequiredClaims: []string{"scope", "jti"}}, auth.Claims{Set: map[string]interface{}{"scope": "read"}}, time.Minute, false, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClock := clock.NewFake(start)
+			signingKey := key
+			if test.otherKey {
+				signingKey = otherKey
+			}
+			factory := auth.TokenFactory{SigningKey: signingKey, Algorithm: jwt.RS256, Clock: fakeClock}
+			token, err := factory.Sign(test.claims, test.validTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.config.PublicKeys = []*rsa.PublicKey{&key.PublicKey}
+			test.config.Clock = fakeClock
+			authenticator, err := NewJWTAuthenticator(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fakeClock.Advance(test.advance)
+			_, err = authenticator.Verify(string(token))
+			if test.valid && err != nil {
+				t.Errorf("Expected the token to be accepted, got %s", err)
+			}
+			if !test.valid && !errors.Is(err, ErrorInvalidAuthToken) {
+				t.Errorf("Expected the token to be rejected with ErrorInvalidAuthToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewJWTAuthenticatorRequiresPublicKeys(t *testing.T) {
+	if _, err := NewJWTAuthenticator(JWTAuthConfig{}); !errors.Is(err, ErrInvalidJWTAuthConfig) {
+		t.Errorf("Expected ErrInvalidJWTAuthConfig, got %v", err)
+	}
+}
+
+func TestJWTAuthenticatorAuthenticatesPrincipal(t *testing.T) {
+	key, _ := testSigningKeys(t)
+	factory := auth.TokenFactory{SigningKey: key, Algorithm: jwt.RS256}
+	authenticator, err := NewJWTAuthenticator(JWTAuthConfig{PublicKeys: []*rsa.PublicKey{&key.PublicKey}, ClientIDClaim: "client_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var principal Principal
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFrom(r.Context())
+	}), RequestAuthMiddleware(authenticator, &testLogger{}))
+	serve := func(claims auth.Claims) int {
+		token, err := factory.Sign(claims, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodGet, "/v1/things", nil)
+		request.Header.Set("Authorization", "Bearer "+string(token))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if status := serve(auth.Claims{Set: map[string]interface{}{"client_id": "client-1", "scope": "read"}}); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if principal.ClientID != "client-1" || principal.Method != AuthMethodJWT || principal.Claims["scope"] != "read" || principal.JWT == nil {
+		t.Errorf("Expected a JWT principal carrying the token's claims, got %+v", principal)
+	}
+	if status := serve(auth.Claims{Registered: jwt.Registered{Subject: "client-1"}}); status != http.StatusUnauthorized {
+		t.Errorf("Expected tokens without the client ID claim to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	apierrors "github.com/tozny/utils-go/errors"
+)
+
+const (
+	// DefaultMaxBodyBytes is the largest request body accepted when no other limit is configured
+	DefaultMaxBodyBytes = 10 << 20
+)
+
+var (
+	// ErrInvalidBodyLimits is returned when BodyLimits has an override without a route
+	ErrInvalidBodyLimits = errors.New("invalid body limits")
+)
+
+// BodyLimitOverride sets the body size limit for requests to matching routes.
+type BodyLimitOverride struct {
+	Route    *regexp.Regexp // Request paths matching this use MaxBytes
+	MaxBytes int64          // Largest body accepted for matching routes, negative for no limit
+}
+
+// BodyLimits configures the largest request body accepted, with per route overrides.
+type BodyLimits struct {
+	MaxBytes  int64               // Largest body accepted, zero uses DefaultMaxBodyBytes and negative for no limit
+	Overrides []BodyLimitOverride // Overrides checked in order, the first matching route's limit is used
+}
+
+// Validate returns ErrInvalidBodyLimits if any override has no route.
+func (l BodyLimits) Validate() error {
+	for index, override := range l.Overrides {
+		if override.Route == nil {
+			return fmt.Errorf("%w: override %d has no route", ErrInvalidBodyLimits, index)
+		}
+	}
+	return nil
+}
+
+// mustValidate panics if l is invalid, so misconfigured limits are caught when
+// middleware is constructed rather than when a request is matched.
+func (l BodyLimits) mustValidate() {
+	if err := l.Validate(); err != nil {
+		panic(err)
+	}
+}
+
+// Limit returns the largest body accepted for r, or a negative value if there is no limit.
+func (l BodyLimits) Limit(r *http.Request) int64 {
+	for _, override := range l.Overrides {
+		if override.Route.MatchString(r.URL.Path) {
+			return override.MaxBytes
+		}
+	}
+	if l.MaxBytes == 0 {
+		return DefaultMaxBodyBytes
+	}
+	return l.MaxBytes
+}
+
+// apply limits r's body to the limit for r using http.MaxBytesReader.
+func (l BodyLimits) apply(w http.ResponseWriter, r *http.Request) {
+	if limit := l.Limit(r); limit >= 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+}
+
+// BodyLimitMiddleware provides http middleware which limits the size of request bodies
+// according to limits. Handlers reading past the limit receive an *http.MaxBytesError,
+// which UnmarshalJSONRequest and WriteError report as a 413 Request Entity Too Large.
+// BodyLimitMiddleware panics if limits are invalid, see BodyLimits.Validate.
+func BodyLimitMiddleware(limits BodyLimits) Middleware {
+	limits.mustValidate()
+	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		limits.apply(w, r)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// bodyReadError returns a typed error for a failure reading a request body,
+// a 413 if the body exceeded its limit and a 400 otherwise.
+func bodyReadError(err error) *apierrors.Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return apierrors.Wrap(err, apierrors.CodeRequestTooLarge, "Request body too large").
+			WithDetail("request body exceeds limit of %d bytes", maxBytesErr.Limit)
+	}
+	return apierrors.Wrap(err, apierrors.CodeInvalidArgument, "Request body could not be read")
+}
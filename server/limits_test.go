@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	apierrors "github.com/tozny/utils-go/errors"
+)
+
+// errorReader fails every read, simulating a client disconnecting mid body.
+type errorReader struct{}
+
+func (errorReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+// decodeErrorResponse decodes the ErrorResponse written to recorder.
+func decodeErrorResponse(t *testing.T, recorder *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var response ErrorResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatalf("Expected a JSON error response, got %q: %s", recorder.Body, err)
+	}
+	return response
+}
+
+func TestBodyLimitsSelectsRouteLimit(t *testing.T) {
+	limits := BodyLimits{
+		MaxBytes: 64,
+		Overrides: []BodyLimitOverride{
+			{Route: regexp.MustCompile("^/v1/uploads"), MaxBytes: -1},
+			{Route: regexp.MustCompile("^/v1/"), MaxBytes: 16},
+		},
+	}
+	for path, expected := range map[string]int64{"/v1/uploads/1": -1, "/v1/things": 16, "/v2/things": 64} {
+		if limit := limits.Limit(httptest.NewRequest(http.MethodPost, path, nil)); limit != expected {
+			t.Errorf("Expected limit %d for %s, got %d", expected, path, limit)
+		}
+	}
+	if limit := (BodyLimits{}).Limit(httptest.NewRequest(http.MethodPost, "/", nil)); limit != DefaultMaxBodyBytes {
+		t.Errorf("Expected the default limit, got %d", limit)
+	}
+}
+
+func TestBodyLimitsRejectsOverrideWithoutRoute(t *testing.T) {
+	limits := BodyLimits{Overrides: []BodyLimitOverride{{Route: regexp.MustCompile("^/v1/"), MaxBytes: 16}, {MaxBytes: -1}}}
+	if err := limits.Validate(); !errors.Is(err, ErrInvalidBodyLimits) {
+		t.Errorf("Expected ErrInvalidBodyLimits, got %v", err)
+	}
+	for name, construct := range map[string]func(){
+		"BodyLimitMiddleware":             func() { BodyLimitMiddleware(limits) },
+		"ExtractBodyMiddlewareWithLimits": func() { ExtractBodyMiddlewareWithLimits(&testLogger{}, limits) },
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidBodyLimits) {
+					t.Errorf("Expected %s to panic with ErrInvalidBodyLimits, got %v", name, err)
+				}
+			}()
+			construct()
+		}()
+	}
+}
+
+func TestExtractBodyMiddlewareWithLimits(t *testing.T) {
+	var handlerBody []byte
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerBody, _ = io.ReadAll(r.Body)
+	}), ExtractBodyMiddlewareWithLimits(&testLogger{}, BodyLimits{MaxBytes: 8}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small")))
+	if recorder.Code != http.StatusOK || string(handlerBody) != "small" {
+		t.Errorf("Expected body within the limit to reach the handler, got %d and %q", recorder.Code, handlerBody)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("far too large")))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", recorder.Code)
+	}
+	if response := decodeErrorResponse(t, recorder); response.Code != apierrors.CodeRequestTooLarge {
+		t.Errorf("Expected code %s, got %+v", apierrors.CodeRequestTooLarge, response)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", io.NopCloser(errorReader{})))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unreadable body, got %d", recorder.Code)
+	}
+	if response := decodeErrorResponse(t, recorder); strings.Contains(response.Message, "connection reset") {
+		t.Errorf("Expected the read error not to be exposed, got %+v", response)
+	}
+}
+
+func TestUnmarshalJSONRequestLimit(t *testing.T) {
+	var decoded map[string]string
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"tozny"}`))
+	if err := UnmarshalJSONRequestLimit(request, &decoded, 64); err != nil || decoded["name"] != "tozny" {
+		t.Errorf("Expected body to decode, got %v and %v", decoded, err)
+	}
+	var typed *apierrors.Error
+	request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"tozny"}`))
+	if err := UnmarshalJSONRequestLimit(request, &decoded, 4); !errors.As(err, &typed) || typed.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a 413 error, got %v", err)
+	}
+	request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":`))
+	if err := UnmarshalJSONRequestLimit(request, &decoded, -1); !errors.As(err, &typed) || typed.Status != http.StatusBadRequest {
+		t.Errorf("Expected a 400 error, got %v", err)
+	}
+}
+
+func TestWriteErrorReportsBodyLimit(t *testing.T) {
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			WriteError(w, r, nil, err)
+		}
+	}), BodyLimitMiddleware(BodyLimits{MaxBytes: 4}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large")))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", recorder.Code)
+	}
+}
@@ -3,14 +3,21 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"regexp"
+	"runtime/debug"
 	"strings"
 
+	"github.com/tozny/utils-go/breaker"
+	"github.com/tozny/utils-go/clock"
+	apierrors "github.com/tozny/utils-go/errors"
 	"github.com/tozny/utils-go/logging"
+	"github.com/tozny/utils-go/requestid"
 )
 
 const (
@@ -30,6 +37,8 @@ const (
 
 var (
 	// DefaultCORSHeaders is a full set of CORS headers for use in the CORS middleware
+	//
+	// Deprecated: use CORSMiddlewareWithConfig with a CORSConfig.
 	DefaultCORSHeaders = []http.Header{
 		// https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS#The_HTTP_response_headers
 		map[string][]string{
@@ -75,51 +84,240 @@ func DecorateHandlerFunc(f func(http.ResponseWriter, *http.Request), middleware
 	return ApplyMiddleware(http.HandlerFunc(f), middleware...)
 }
 
-// JSONLoggingMiddleware wraps an HTTP handler and logs
-// the request and de-serialized JSON body.
+// JSONLoggingConfig wraps configuration for JSONLoggingMiddlewareWithConfig.
+type JSONLoggingConfig struct {
+	Logger                logging.Logger   // Logger to write request logs to at debug level
+	RouteLoggingBlacklist []*regexp.Regexp // Request URIs matching any of these are not logged
+	RedactFields          []string         // JSON fields to redact from logged bodies, nil uses DefaultRedactedFields
+	RedactHeaders         []string         // Header name rules to redact from logged requests, matching any header containing them, nil uses DefaultRedactedHeaders
+	MaxBodyBytes          int64            // Number of body bytes to log, zero uses DefaultMaxLoggedBodyBytes
+}
+
+// JSONLoggingMiddleware wraps an HTTP handler and logs the request and de-serialized
+// JSON body, redacting the default sensitive fields and headers.
 func JSONLoggingMiddleware(logger logging.Logger, routeLoggingBlacklist []*regexp.Regexp) Middleware {
+	return JSONLoggingMiddlewareWithConfig(JSONLoggingConfig{
+		Logger:                logger,
+		RouteLoggingBlacklist: routeLoggingBlacklist,
+	})
+}
+
+// JSONLoggingMiddlewareWithConfig wraps an HTTP handler and logs the request headers
+// and JSON body. Sensitive JSON fields and headers are redacted, bodies with a non JSON
+// content type are omitted and at most config.MaxBodyBytes of the body are read for
+// logging, the rest of the body is streamed to the handler without being buffered.
+func JSONLoggingMiddlewareWithConfig(config JSONLoggingConfig) Middleware {
+	if config.RedactFields == nil {
+		config.RedactFields = DefaultRedactedFields
+	}
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = DefaultRedactedHeaders
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = DefaultMaxLoggedBodyBytes
+	}
+	redactor := newRedactor(config.RedactFields, config.RedactHeaders)
 	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
 		// Only log the request if the route isn't blacklisted
-		for _, routeBlacklistRegex := range routeLoggingBlacklist {
+		for _, routeBlacklistRegex := range config.RouteLoggingBlacklist {
 			if routeBlacklistRegex.MatchString(r.RequestURI) {
 				h.ServeHTTP(w, r)
 				return
 			}
 		}
-		var bodyBytes []byte
-		var err error
-		if r.Body != nil {
-			bodyBytes, err = ioutil.ReadAll(r.Body)
+		var loggedBody string
+		var truncated bool
+		contentType := r.Header.Get("Content-Type")
+		switch {
+		case r.Body == nil || r.Body == http.NoBody:
+		case !isJSONContentType(contentType):
+			loggedBody = omittedBody("content type " + contentType)
+		default:
+			// Read one byte past the limit to detect truncation
+			bodyBytes, err := ioutil.ReadAll(io.LimitReader(r.Body, config.MaxBodyBytes+1))
 			if err != nil {
-				logger.Errorf("Error reading request body %s", err)
+				config.Logger.Errorf("Error reading request body %s", err)
+			}
+			// Repopulate body with the data read followed by the unread remainder
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(bodyBytes), r.Body), r.Body}
+			truncated = int64(len(bodyBytes)) > config.MaxBodyBytes
+			if truncated {
+				bodyBytes = bodyBytes[:config.MaxBodyBytes]
+			}
+			var ok bool
+			if loggedBody, ok = redactor.redactBody(bodyBytes, truncated); !ok {
+				loggedBody = omittedBody("invalid JSON")
 			}
 		}
-		// Repopulate body with the data read
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-		logger.Debug(map[string]interface{}{
-			"request_method":    r.Method,
-			"request_uri":       r.RequestURI,
-			"requester_address": r.RemoteAddr,
-			"requester_host":    r.Host,
-			"request_body":      string(bodyBytes),
+		config.Logger.Debug(map[string]interface{}{
+			"request_method":         r.Method,
+			"request_uri":            r.RequestURI,
+			"requester_address":      r.RemoteAddr,
+			"requester_host":         r.Host,
+			"request_headers":        redactor.redactHeaders(r.Header),
+			"request_body":           loggedBody,
+			"request_body_truncated": truncated,
+			"request_id":             requestid.FromRequest(r),
 		})
 		h.ServeHTTP(w, r)
 	})
 }
 
+// readCloser combines a Reader with the Closer of the body it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// AccessLogConfig wraps configuration for AccessLogMiddleware.
+type AccessLogConfig struct {
+	Logger         logging.StructuredLogger     // Logger to write access logs to
+	SampleRate     float64                      // Fraction (0 to 1) of requests to log, zero logs every request. Server errors are always logged
+	Route          func(r *http.Request) string // Returns the route name logged for a request, nil logs the URL path
+	RouteBlacklist []*regexp.Regexp             // Request URIs matching any of these are not logged
+	Clock          clock.Clock                  // Source of time for measuring latency, nil uses the system clock
+}
+
+// AccessLogMiddleware provides http middleware which writes one structured log line
+// per request via config.Logger.Infow, recording the method, route, status code,
+// latency, response size, authenticated client ID and requester IP. The client ID is
+// logged when authentication middleware is applied either inside or outside of it.
+// Requests whose handler panics are logged with status 500 before the panic continues.
+func AccessLogMiddleware(config AccessLogConfig) Middleware {
+	timeSource := clock.OrNew(config.Clock)
+	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		for _, routeBlacklistRegex := range config.RouteBlacklist {
+			if routeBlacklistRegex.MatchString(r.RequestURI) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		start := timeSource.Now()
+		ctx, slot := withPrincipalSlot(r.Context())
+		recorder := WrapResponseWriter(w)
+		completed := false
+		// Logged from a deferred call so that requests which panic are logged, the
+		// panic then continuing to unwind to any recovery middleware outside of this one
+		defer func() {
+			status := recorder.Status()
+			if !completed {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				// Handlers which write nothing respond with an implicit 200
+				status = http.StatusOK
+			}
+			sampled := config.SampleRate <= 0 || config.SampleRate >= 1 || rand.Float64() < config.SampleRate
+			if !sampled && status < http.StatusInternalServerError {
+				return
+			}
+			route := r.URL.Path
+			if config.Route != nil {
+				route = config.Route(r)
+			}
+			config.Logger.Infow("access", r,
+				"request-method", r.Method,
+				"route", route,
+				"status", status,
+				"latency-ms", float64(timeSource.Since(start).Microseconds())/1000,
+				"response-bytes", recorder.BytesWritten(),
+				"client-id", slot.clientID(r.Context()))
+		}()
+		h.ServeHTTP(recorder, r.WithContext(ctx))
+		completed = true
+	})
+}
+
+// PanicHook is called with the request, recovered value and stack trace of every
+// panic caught by RecoveryMiddleware, e.g. to report panics to an error tracker.
+type PanicHook func(r *http.Request, recovered interface{}, stack []byte)
+
+// RecoveryMiddleware provides http middleware which recovers from panics in the
+// decorated handler, logging the panic, stack trace and request metadata via
+// logger.CriticalW, calling each hook, and responding through WriteError with a JSON 500
+// if the handler had not already started its response.
+// Panics with http.ErrAbortHandler are re-raised so the server aborts the response as intended.
+func RecoveryMiddleware(logger logging.StructuredLogger, hooks ...PanicHook) Middleware {
+	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		ctx, slot := withPrincipalSlot(r.Context())
+		recorder := WrapResponseWriter(w)
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			stack := debug.Stack()
+			logger.CriticalW("RecoveryMiddleware: recovered from panic", r,
+				"panic", fmt.Sprint(recovered),
+				"stack", string(stack),
+				"request-method", r.Method,
+				"request-uri", r.RequestURI,
+				"client-id", slot.clientID(r.Context()))
+			for _, hook := range hooks {
+				callPanicHook(logger, hook, r, recovered, stack)
+			}
+			if recorder.Status() != 0 {
+				// The response has already started so the status can not be changed
+				return
+			}
+			// Already logged above, so no logger is passed
+			WriteError(recorder, r, nil, apierrors.Internal(nil))
+		}()
+		h.ServeHTTP(recorder, r.WithContext(ctx))
+	})
+}
+
+// callPanicHook calls hook, logging rather than propagating any panic from the hook
+// itself so that every hook runs and the error response is still written.
+func callPanicHook(logger logging.StructuredLogger, hook PanicHook, r *http.Request, recovered interface{}, stack []byte) {
+	defer func() {
+		if hookPanic := recover(); hookPanic != nil {
+			logger.Errorw("RecoveryMiddleware: panic hook panicked", r, "panic", fmt.Sprint(hookPanic))
+		}
+	}()
+	hook(r, recovered, stack)
+}
+
+// RequestIDMiddleware provides http middleware which tags each request with a request ID,
+// accepting a valid ID from the caller's requestid.Header or generating a new one. The ID
+// is stored in the request context for handlers, loggers and outbound calls to read with
+// requestid.FromContext or requestid.FromRequest, and is echoed in the response header.
+func RequestIDMiddleware() Middleware {
+	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+			r.Header.Set(requestid.Header, id)
+		}
+		w.Header().Set(requestid.Header, id)
+		h.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
 // CORSMiddleware provides http middleware for allowing cross origin requests by
-// decorating the request with the provided CORS headers and returning default 200 OK for any options requests
+// decorating the request with the provided CORS headers and returning default 200 OK for
+// any preflight requests. Access-Control-Allow-Credentials is removed when sent together
+// with a wildcard Access-Control-Allow-Origin, as browsers reject that combination and
+// reflecting the request's origin instead would let any website read responses on behalf
+// of logged in users.
+//
+// Deprecated: use CORSMiddlewareWithConfig, which restricts origins to an allowlist.
 func CORSMiddleware(corsHeaders []http.Header) Middleware {
 	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
 		for _, corsHeader := range corsHeaders {
 			for key, values := range corsHeader {
 				for _, value := range values {
-					w.Header().Set(key, value)
+					header.Set(key, value)
 				}
 			}
 		}
-		switch r.Method {
-		case http.MethodOptions:
+		if header.Get("Access-Control-Allow-Origin") == CORSAllowAll {
+			header.Del("Access-Control-Allow-Credentials")
+		}
+		if isPreflightRequest(r) {
 			HandleOptionsRequest(w)
 			return
 		}
@@ -142,25 +340,59 @@ type e3dbTokenRequestAuthenticator struct {
 }
 
 func (auth e3dbTokenRequestAuthenticator) AuthenticateRequest(ctx context.Context, r *http.Request) (string, error) {
-	// Check to see if this request is a health or service check requests
+	principal, err := auth.AuthenticatePrincipal(ctx, r)
+	return principal.ClientID, err
+}
+
+func (auth e3dbTokenRequestAuthenticator) AuthenticatePrincipal(ctx context.Context, r *http.Request) (Principal, error) {
 	token, err := ExtractBearerToken(r)
 	if err != nil {
-		return "", fmt.Errorf("e3dbAuthHandler: error extracting bearer token %s", err)
+		return Principal{}, fmt.Errorf("e3dbAuthHandler: error extracting bearer token %s", err)
 	}
 	clientID, valid, err := auth.AuthenticateE3DBClient(ctx, token, auth.internal)
 	if err != nil || !valid {
-		return "", fmt.Errorf("e3dbAuthHandler: error validating token valid %t, err: %s", valid, err)
+		return Principal{}, fmt.Errorf("e3dbAuthHandler: error validating token valid %t, err: %w", valid, err)
 	}
-	// Add the token to the request headers
-	r.Header.Set(ToznyOpenAuthenticationTokenHeader, token)
-	return clientID, err
+	return Principal{ClientID: clientID, Token: token, Method: AuthMethodBearerToken}, nil
+}
+
+// breakerTokenAuthenticator decorates an E3DBTokenAuthenticator, routing every
+// authentication call through a circuit breaker.
+type breakerTokenAuthenticator struct {
+	E3DBTokenAuthenticator
+	breaker *breaker.Breaker
+}
+
+func (auth breakerTokenAuthenticator) AuthenticateE3DBClient(ctx context.Context, token string, internal bool) (string, bool, error) {
+	var clientID string
+	var valid bool
+	err := auth.breaker.Execute(func() error {
+		var err error
+		clientID, valid, err = auth.E3DBTokenAuthenticator.AuthenticateE3DBClient(ctx, token, internal)
+		return err
+	})
+	return clientID, valid, err
+}
+
+// BreakerTokenAuthenticator wraps auth so that authentication fails fast with
+// breaker.ErrOpen while the provided circuit breaker is open. Only errors count as
+// failures, tokens reported as invalid are a healthy response from the authenticator.
+func BreakerTokenAuthenticator(auth E3DBTokenAuthenticator, circuitBreaker *breaker.Breaker) E3DBTokenAuthenticator {
+	return breakerTokenAuthenticator{auth, circuitBreaker}
 }
 
 // AuthMiddleware provides http middleware for enforcing requests as coming from e3db
 // authenticated entities (either external or internal clients) for any request with a path
 // not ending in `HealthCheckPathSuffix` or `ServiceCheckPathSuffix` via a function which validates a Bearer token
 func AuthMiddleware(auth E3DBTokenAuthenticator, privateService bool, logger logging.Logger) Middleware {
-	return RequestAuthMiddleware(&e3dbTokenRequestAuthenticator{auth, privateService}, logger)
+	return RequestAuthMiddleware(TokenRequestAuthenticator(auth, privateService), logger)
+}
+
+// TokenRequestAuthenticator adapts auth to a PrincipalAuthenticator which authenticates
+// requests by their Bearer token, e.g. for registering with a CompositeAuthenticator.
+// Internal requires the token to belong to an internal client.
+func TokenRequestAuthenticator(auth E3DBTokenAuthenticator, internal bool) PrincipalAuthenticator {
+	return &e3dbTokenRequestAuthenticator{auth, internal}
 }
 
 // A RequestAuthenticator provides the ability to authenticate
@@ -172,11 +404,41 @@ type RequestAuthenticator interface {
 	AuthenticateRequest(ctx context.Context, request *http.Request) (clientID string, err error)
 }
 
+// A PrincipalAuthenticator is a RequestAuthenticator which can also report the full
+// principal a request authenticates, including the token and claims it presented.
+// RequestAuthMiddleware uses AuthenticatePrincipal for authenticators implementing it.
+type PrincipalAuthenticator interface {
+	RequestAuthenticator
+	// AuthenticatePrincipal validates the provided request authenticates
+	// an internal OR external e3db client, returning its principal and error (if any).
+	AuthenticatePrincipal(ctx context.Context, request *http.Request) (Principal, error)
+}
+
+// AuthConfig wraps configuration for RequestAuthMiddlewareWithConfig.
+type AuthConfig struct {
+	Authenticator RequestAuthenticator // Authenticates each request, implement PrincipalAuthenticator to report tokens and claims
+	Logger        logging.Logger       // Logger for authentication failures
+	// SetIdentityHeaders additionally sets ToznyClientIDHeader and ToznyOpenAuthenticationTokenHeader
+	// on authenticated requests for handlers which have not moved to PrincipalFrom. When false
+	// both headers are removed so handlers can not be misled by values sent by the client.
+	SetIdentityHeaders bool
+}
+
 // RequestAuthMiddleware provides http middleware for enforcing requests as coming from e3db
 // authenticated entities (either external or internal clients) for any request with a path
 // not ending in `HealthCheckPathSuffix` or `ServiceCheckPathSuffix` via a function which
-// validates the http.Request
+// validates the http.Request. The authenticated Principal is available to handlers via PrincipalFrom.
 func RequestAuthMiddleware(auth RequestAuthenticator, logger logging.Logger) Middleware {
+	return RequestAuthMiddlewareWithConfig(AuthConfig{
+		Authenticator: auth,
+		Logger:        logger,
+	})
+}
+
+// RequestAuthMiddlewareWithConfig is RequestAuthMiddleware configured by config.
+// The authenticator is called with the request's context, so authentication is
+// cancelled along with the request.
+func RequestAuthMiddlewareWithConfig(config AuthConfig) Middleware {
 	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
 		// Check to see if this request is a health or service check requests
 		requestPath := r.URL.Path
@@ -186,20 +448,43 @@ func RequestAuthMiddleware(auth RequestAuthenticator, logger logging.Logger) Mid
 			h.ServeHTTP(w, r)
 			return
 		}
-		ctx := context.Background()
-		clientID, err := auth.AuthenticateRequest(ctx, r)
+		principal, err := authenticatePrincipal(r.Context(), config.Authenticator, r)
+		if errors.Is(err, breaker.ErrOpen) || errors.Is(err, breaker.ErrTooManyRequests) || errors.Is(err, ErrorAuthenticationUnavailable) {
+			config.Logger.Errorf("RequestAuthMiddleware: request %s: authenticator unavailable: %s\n", requestid.FromRequest(r), err)
+			HandleError(w, http.StatusServiceUnavailable, ErrorAuthenticationUnavailable)
+			return
+		}
 		if err != nil {
-			logger.Errorf("RequestAuthMiddleware: error validating request: %s\n", err)
+			config.Logger.Errorf("RequestAuthMiddleware: request %s: error validating request: %s\n", requestid.FromRequest(r), err)
 			HandleError(w, http.StatusUnauthorized, ErrorInvalidAuthentication)
 			return
 		}
-		// Add the clients id and token to the request headers
-		r.Header.Set(ToznyClientIDHeader, clientID)
+		r.Header.Del(ToznyClientIDHeader)
+		r.Header.Del(ToznyOpenAuthenticationTokenHeader)
+		if config.SetIdentityHeaders {
+			r.Header.Set(ToznyClientIDHeader, principal.ClientID)
+			if principal.Token != "" {
+				r.Header.Set(ToznyOpenAuthenticationTokenHeader, principal.Token)
+			}
+		}
+		recordPrincipal(r.Context(), principal)
 		// Authenticated, continue processing request
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
 	})
 }
 
+// authenticatePrincipal authenticates r with auth, using AuthenticatePrincipal if auth supports it.
+func authenticatePrincipal(ctx context.Context, auth RequestAuthenticator, r *http.Request) (Principal, error) {
+	if principalAuth, ok := auth.(PrincipalAuthenticator); ok {
+		return principalAuth.AuthenticatePrincipal(ctx, r)
+	}
+	clientID, err := auth.AuthenticateRequest(ctx, r)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{ClientID: clientID, Method: AuthMethodRequest}, nil
+}
+
 // TrimSlash is middleware to trim trailing slashes from request paths for usability. Without this
 // requests to example.com/path works and example.com/path/ fails miserably. This makes them work the same.
 func TrimSlash(h http.Handler) http.Handler {
@@ -215,18 +500,29 @@ func TrimSlash(h http.Handler) http.Handler {
 // middleware chain. To use the raw body, get the value from the context and then
 // use type assertion to a []byte slice. With this middleware, the request body
 // can be read once, and then accessed by all future middleware and the final
-// http handler.
+// http handler. Bodies larger than DefaultMaxBodyBytes are rejected with a 413.
 func ExtractBodyMiddleware(logger logging.Logger) Middleware {
+	return ExtractBodyMiddlewareWithLimits(logger, BodyLimits{})
+}
+
+// ExtractBodyMiddlewareWithLimits is ExtractBodyMiddleware with the body size limited by
+// limits. Requests whose body exceeds its limit are rejected with a JSON 413 and requests
+// whose body can not be read for any other reason are rejected with a JSON 400.
+// It panics if limits are invalid, see BodyLimits.Validate.
+func ExtractBodyMiddlewareWithLimits(logger logging.Logger, limits BodyLimits) Middleware {
+	limits.mustValidate()
 	return MiddlewareFunc(func(next http.Handler, w http.ResponseWriter, r *http.Request) {
 		var rawBody []byte
 		if r.Body != nil {
+			limits.apply(w, r)
 			var rawBodyBuffer bytes.Buffer
 			// Read the request body
 			body := io.TeeReader(r.Body, &rawBodyBuffer)
 			var err error
 			rawBody, err = ioutil.ReadAll(body)
 			if err != nil {
-				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				logger.Errorf("ExtractBodyMiddleware: error reading request body: %s\n", err)
+				WriteError(w, r, nil, bodyReadError(err))
 				return
 			}
 			// Repopulate the request body for the ultimate consumer of this request
@@ -0,0 +1,504 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/clock"
+	"github.com/tozny/utils-go/requestid"
+)
+
+// logEntry is a single call to a testLogger method.
+type logEntry struct {
+	level         string
+	message       string
+	keysAndValues []interface{}
+}
+
+// value returns the value logged for key, or nil if key was not logged.
+func (entry logEntry) value(key string) interface{} {
+	for i := 0; i+1 < len(entry.keysAndValues); i += 2 {
+		if entry.keysAndValues[i] == key {
+			return entry.keysAndValues[i+1]
+		}
+	}
+	return nil
+}
+
+// testLogger is a logging.StructuredLogger recording every entry for inspection.
+type testLogger struct {
+	mutex   sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level string, message string, keysAndValues ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, message: message, keysAndValues: keysAndValues})
+}
+
+// Entries returns a copy of the entries logged so far.
+func (l *testLogger) Entries() []logEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]logEntry(nil), l.entries...)
+}
+
+// String returns every entry logged so far, one per line.
+func (l *testLogger) String() string {
+	var lines []string
+	for _, entry := range l.Entries() {
+		lines = append(lines, fmt.Sprint(entry.level, " ", entry.message, " ", entry.keysAndValues))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (l *testLogger) SetLevel(string) {}
+
+func (l *testLogger) Print(v ...interface{}) { l.log("print", fmt.Sprint(v...)) }
+
+func (l *testLogger) Println(v ...interface{}) { l.log("print", fmt.Sprint(v...)) }
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.log("print", fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Debug(v ...interface{}) { l.log("debug", fmt.Sprint(v...)) }
+
+func (l *testLogger) Debugln(v ...interface{}) { l.log("debug", fmt.Sprint(v...)) }
+
+func (l *testLogger) Debugf(format string, v ...interface{}) {
+	l.log("debug", fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Info(v ...interface{}) { l.log("info", fmt.Sprint(v...)) }
+
+func (l *testLogger) Infoln(v ...interface{}) { l.log("info", fmt.Sprint(v...)) }
+
+func (l *testLogger) Infof(format string, v ...interface{}) {
+	l.log("info", fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Warn(v ...interface{}) { l.log("warn", fmt.Sprint(v...)) }
+
+func (l *testLogger) Warnln(v ...interface{}) { l.log("warn", fmt.Sprint(v...)) }
+
+func (l *testLogger) Warnf(format string, v ...interface{}) {
+	l.log("warn", fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Error(v ...interface{}) { l.log("error", fmt.Sprint(v...)) }
+
+func (l *testLogger) Errorln(v ...interface{}) { l.log("error", fmt.Sprint(v...)) }
+
+func (l *testLogger) Errorf(format string, v ...interface{}) {
+	l.log("error", fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Critical(v ...interface{}) { l.log("critical", fmt.Sprint(v...)) }
+
+func (l *testLogger) Criticalln(v ...interface{}) { l.log("critical", fmt.Sprint(v...)) }
+
+func (l *testLogger) Criticalf(format string, v ...interface{}) {
+	l.log("critical", fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Debugw(message string, r *http.Request, v ...interface{}) {
+	l.log("debug", message, v...)
+}
+
+func (l *testLogger) Infow(message string, r *http.Request, v ...interface{}) {
+	l.log("info", message, v...)
+}
+
+func (l *testLogger) Warnw(message string, r *http.Request, v ...interface{}) {
+	l.log("warn", message, v...)
+}
+
+func (l *testLogger) Errorw(message string, r *http.Request, v ...interface{}) {
+	l.log("error", message, v...)
+}
+
+func (l *testLogger) CriticalW(message string, r *http.Request, v ...interface{}) {
+	l.log("critical", message, v...)
+}
+
+func TestRecoveryMiddlewareRespondsWithInternalError(t *testing.T) {
+	logger := &testLogger{}
+	var hookCalls int
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler panicked")
+	}), RecoveryMiddleware(logger,
+		func(r *http.Request, recovered interface{}, stack []byte) { panic("hook panicked") },
+		func(r *http.Request, recovered interface{}, stack []byte) { hookCalls++ },
+	))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/things", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", recorder.Code)
+	}
+	if strings.Contains(recorder.Body.String(), "panicked") {
+		t.Errorf("Expected the panic not to be exposed, got %s", recorder.Body)
+	}
+	if hookCalls != 1 {
+		t.Errorf("Expected hooks after a panicking hook to run, got %d calls", hookCalls)
+	}
+	if logged := logger.String(); !strings.Contains(logged, "handler panicked") || !strings.Contains(logged, "hook panicked") {
+		t.Errorf("Expected both panics to be logged, got\n%s", logged)
+	}
+}
+
+func TestRecoveryMiddlewareReraisesAbortHandler(t *testing.T) {
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), RecoveryMiddleware(&testLogger{}))
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to be re-raised, got %v", recovered)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestAccessLogMiddlewareRecordsResponse(t *testing.T) {
+	logger := &testLogger{}
+	fakeClock := clock.NewFake(time.Now())
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fakeClock.Advance(1500 * time.Microsecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}), AccessLogMiddleware(AccessLogConfig{
+		Logger: logger,
+		Route:  func(r *http.Request) string { return "/v1/things/{id}" },
+		Clock:  fakeClock,
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/things/42", nil))
+	entries := logger.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("Expected one access log entry, got\n%s", logger)
+	}
+	expected := map[string]interface{}{
+		"request-method": http.MethodPost,
+		"route":          "/v1/things/{id}",
+		"status":         http.StatusCreated,
+		"latency-ms":     1.5,
+		"response-bytes": int64(len("created")),
+	}
+	for key, value := range expected {
+		if logged := entries[0].value(key); logged != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, logged)
+		}
+	}
+}
+
+func TestAccessLogMiddlewareDefaultsAndFiltering(t *testing.T) {
+	logger := &testLogger{}
+	status := http.StatusOK
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
+	}), AccessLogMiddleware(AccessLogConfig{
+		Logger:         logger,
+		SampleRate:     0.0000001,
+		RouteBlacklist: []*regexp.Regexp{regexp.MustCompile("/healthcheck$")},
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/things/healthcheck", nil))
+	if entries := logger.Entries(); len(entries) != 0 {
+		t.Errorf("Expected blacklisted route not to be logged, got\n%s", logger)
+	}
+	status = http.StatusServiceUnavailable
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/things", nil))
+	entries := logger.Entries()
+	if len(entries) != 1 || entries[0].value("status") != http.StatusServiceUnavailable || entries[0].value("route") != "/v1/things" {
+		t.Errorf("Expected unsampled server error to be logged with the URL path, got\n%s", logger)
+	}
+}
+
+func TestWrapResponseWriterRecordsStatusAndSize(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := WrapResponseWriter(recorder)
+	if writer.Status() != 0 {
+		t.Errorf("Expected no status before the response starts, got %d", writer.Status())
+	}
+	writer.Write([]byte("hello "))
+	writer.WriteHeader(http.StatusTeapot)
+	writer.Write([]byte("world"))
+	writer.(http.Flusher).Flush()
+	if writer.Status() != http.StatusOK || writer.BytesWritten() != 11 {
+		t.Errorf("Expected implicit 200 with 11 bytes, got %d with %d bytes", writer.Status(), writer.BytesWritten())
+	}
+	if !recorder.Flushed || recorder.Code != http.StatusOK {
+		t.Errorf("Expected flush to reach the wrapped writer and the first status to win, got %t and %d", recorder.Flushed, recorder.Code)
+	}
+}
+
+func TestWrapResponseWriterIgnoresInformationalStatus(t *testing.T) {
+	writer := WrapResponseWriter(httptest.NewRecorder())
+	writer.WriteHeader(http.StatusEarlyHints)
+	if writer.Status() != 0 {
+		t.Errorf("Expected an informational status not to be recorded, got %d", writer.Status())
+	}
+	writer.WriteHeader(http.StatusCreated)
+	if writer.Status() != http.StatusCreated {
+		t.Errorf("Expected the final status to be recorded, got %d", writer.Status())
+	}
+	switching := WrapResponseWriter(httptest.NewRecorder())
+	switching.WriteHeader(http.StatusSwitchingProtocols)
+	if switching.Status() != http.StatusSwitchingProtocols {
+		t.Errorf("Expected 101 to be recorded as the final status, got %d", switching.Status())
+	}
+}
+
+// plainWriter is an http.ResponseWriter which can neither be flushed nor hijacked.
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+// hijackWriter is an http.ResponseWriter which can be hijacked but not flushed.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (h hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+// hijackRecorder is a ResponseRecorder which can also be hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (h hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestWrapResponseWriterMatchesCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		w        http.ResponseWriter
+		flusher  bool
+		hijacker bool
+	}{
+		{"neither", plainWriter{httptest.NewRecorder()}, false, false},
+		{"flusher", httptest.NewRecorder(), true, false},
+		{"hijacker", hijackWriter{httptest.NewRecorder()}, false, true},
+		{"flusher and hijacker", hijackRecorder{httptest.NewRecorder()}, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := WrapResponseWriter(test.w)
+			if _, ok := writer.(http.Flusher); ok != test.flusher {
+				t.Errorf("Expected http.Flusher %t, got %t", test.flusher, ok)
+			}
+			if _, ok := writer.(http.Hijacker); ok != test.hijacker {
+				t.Errorf("Expected http.Hijacker %t, got %t", test.hijacker, ok)
+			}
+			err := http.NewResponseController(writer).Flush()
+			if test.flusher == errors.Is(err, http.ErrNotSupported) {
+				t.Errorf("Expected http.ResponseController to see the flush capability %t, got %v", test.flusher, err)
+			}
+			if WrapResponseWriter(writer) != writer {
+				t.Errorf("Expected an already wrapped writer to be returned as is")
+			}
+		})
+	}
+}
+
+// tokenAuthenticatorFunc adapts a function to E3DBTokenAuthenticator.
+type tokenAuthenticatorFunc func(ctx context.Context, token string, internal bool) (string, bool, error)
+
+func (f tokenAuthenticatorFunc) AuthenticateE3DBClient(ctx context.Context, token string, internal bool) (string, bool, error) {
+	return f(ctx, token, internal)
+}
+
+// requestAuthenticatorFunc adapts a function to a RequestAuthenticator which does not
+// implement PrincipalAuthenticator.
+type requestAuthenticatorFunc func(ctx context.Context, r *http.Request) (string, error)
+
+func (f requestAuthenticatorFunc) AuthenticateRequest(ctx context.Context, r *http.Request) (string, error) {
+	return f(ctx, r)
+}
+
+// staticTokens authenticates "token-<client>" bearer tokens as <client>.
+var staticTokens = tokenAuthenticatorFunc(func(ctx context.Context, token string, internal bool) (string, bool, error) {
+	if !strings.HasPrefix(token, "token-") {
+		return "", false, nil
+	}
+	return strings.TrimPrefix(token, "token-"), true, nil
+})
+
+// authRequest returns a request to path with the provided Authorization header (if any)
+// and spoofed identity headers.
+func authRequest(path string, authorization string) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	request.Header.Set(ToznyClientIDHeader, "spoofed-client")
+	request.Header.Set(ToznyOpenAuthenticationTokenHeader, "spoofed-token")
+	return request
+}
+
+func TestRequestAuthMiddlewareCarriesPrincipal(t *testing.T) {
+	tests := []struct {
+		name               string
+		setIdentityHeaders bool
+		clientIDHeader     string
+		tokenHeader        string
+	}{
+		{"identity headers stripped", false, "", ""},
+		{"identity headers set", true, "client-1", "token-client-1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var principal Principal
+			var headers http.Header
+			handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, _ = PrincipalFrom(r.Context())
+				headers = r.Header
+			}), RequestAuthMiddlewareWithConfig(AuthConfig{
+				Authenticator:      TokenRequestAuthenticator(staticTokens, false),
+				Logger:             &testLogger{},
+				SetIdentityHeaders: test.setIdentityHeaders,
+			}))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, authRequest("/v1/things", "Bearer token-client-1"))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", recorder.Code)
+			}
+			if principal.ClientID != "client-1" || principal.Token != "token-client-1" || principal.Method != AuthMethodBearerToken {
+				t.Errorf("Expected the authenticated principal in the request context, got %+v", principal)
+			}
+			if actual := headers.Get(ToznyClientIDHeader); actual != test.clientIDHeader {
+				t.Errorf("Expected %s to be %q, got %q", ToznyClientIDHeader, test.clientIDHeader, actual)
+			}
+			if actual := headers.Get(ToznyOpenAuthenticationTokenHeader); actual != test.tokenHeader {
+				t.Errorf("Expected %s to be %q, got %q", ToznyOpenAuthenticationTokenHeader, test.tokenHeader, actual)
+			}
+		})
+	}
+}
+
+func TestRequestAuthMiddlewareRejectsRequests(t *testing.T) {
+	var calls int
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if _, ok := PrincipalFrom(r.Context()); ok {
+			t.Errorf("Expected no principal for unauthenticated monitoring requests")
+		}
+	}), RequestAuthMiddleware(TokenRequestAuthenticator(staticTokens, false), &testLogger{}))
+	for _, authorization := range []string{"", "Bearer unknown", "Basic token-client-1"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, authRequest("/v1/things", authorization))
+		if recorder.Code != http.StatusUnauthorized || strings.Contains(recorder.Body.String(), "spoofed") {
+			t.Errorf("Expected %q to be rejected, got %d with %s", authorization, recorder.Code, recorder.Body)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("Expected rejected requests not to reach the handler, got %d calls", calls)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, authRequest("/v1/things/healthcheck", ""))
+	if recorder.Code != http.StatusOK || calls != 1 {
+		t.Errorf("Expected monitoring requests to skip authentication, got %d", recorder.Code)
+	}
+}
+
+func TestRequestAuthMiddlewareWithRequestAuthenticator(t *testing.T) {
+	var principal Principal
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFrom(r.Context())
+	}), RequestAuthMiddleware(requestAuthenticatorFunc(func(ctx context.Context, r *http.Request) (string, error) {
+		return r.Header.Get("X-Api-Key"), nil
+	}), &testLogger{}))
+	request := authRequest("/v1/things", "")
+	request.Header.Set("X-Api-Key", "client-1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if principal.ClientID != "client-1" || principal.Token != "" || principal.Method != AuthMethodRequest {
+		t.Errorf("Expected a request principal with only the client ID, got %+v", principal)
+	}
+}
+
+func TestMiddlewareLogsClientIDAuthenticatedInside(t *testing.T) {
+	logger := &testLogger{}
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/panic" {
+			panic("handler panicked")
+		}
+	}),
+		RequestAuthMiddleware(TokenRequestAuthenticator(staticTokens, false), logger),
+		AccessLogMiddleware(AccessLogConfig{Logger: logger}),
+		RecoveryMiddleware(logger),
+	)
+	for _, path := range []string{"/v1/things", "/v1/panic"} {
+		handler.ServeHTTP(httptest.NewRecorder(), authRequest(path, "Bearer token-client-1"))
+	}
+	var logged []string
+	var statuses []interface{}
+	for _, entry := range logger.Entries() {
+		if clientID := entry.value("client-id"); clientID != nil {
+			logged = append(logged, entry.message)
+			if clientID != "client-1" {
+				t.Errorf("Expected %q to log the authenticated client ID, got %v", entry.message, clientID)
+			}
+			if entry.message == "access" {
+				statuses = append(statuses, entry.value("status"))
+			}
+		}
+	}
+	// The panicking request is access logged as it unwinds, before it is recovered
+	if len(logged) != 3 || logged[0] != "access" || logged[1] != "access" || !strings.HasPrefix(logged[2], "RecoveryMiddleware") {
+		t.Errorf("Expected access entries for both requests and a recovery entry with the client ID, got\n%s", logger)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusOK || statuses[1] != http.StatusInternalServerError {
+		t.Errorf("Expected statuses 200 and 500 to be logged, got %v", statuses)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		kept     bool
+	}{
+		{"valid id kept", "caller-id-1", true},
+		{"missing id generated", "", false},
+		{"id with spaces replaced", "caller id", false},
+		{"id too long replaced", strings.Repeat("a", requestid.MaxLength+1), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fromContext string
+			handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = requestid.FromContext(r.Context())
+			}), RequestIDMiddleware())
+			request := httptest.NewRequest(http.MethodGet, "/v1/things", nil)
+			if test.incoming != "" {
+				request.Header.Set(requestid.Header, test.incoming)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			echoed := recorder.Header().Get(requestid.Header)
+			if test.kept && echoed != test.incoming {
+				t.Errorf("Expected the incoming id %q to be kept, got %q", test.incoming, echoed)
+			}
+			if !test.kept && (echoed == test.incoming || !requestid.Valid(echoed)) {
+				t.Errorf("Expected a generated id replacing %q, got %q", test.incoming, echoed)
+			}
+			if fromContext != echoed {
+				t.Errorf("Expected the handler to read the echoed id %q from its context, got %q", echoed, fromContext)
+			}
+		})
+	}
+}
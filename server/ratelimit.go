@@ -0,0 +1,127 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "github.com/tozny/utils-go/errors"
+	"github.com/tozny/utils-go/logging"
+	"github.com/tozny/utils-go/ratelimit"
+)
+
+// RateLimitConfig wraps configuration for RateLimitMiddleware.
+type RateLimitConfig struct {
+	Limiter    ratelimit.Limiter            // Limiter deciding whether each request is allowed
+	Key        func(r *http.Request) string // Returns the key a request is limited by, nil uses RateLimitKeyWithProxies(TrustedProxies)
+	Logger     logging.StructuredLogger     // Logger for limiter failures and rejected requests
+	FailClosed bool                         // Whether to reject requests when the limiter fails, by default they are allowed
+	// TrustedProxies are the networks of proxies in front of the service. X-Forwarded-For is
+	// only used to find the requester's IP address when the request came through them.
+	TrustedProxies []*net.IPNet
+}
+
+// RateLimitKey returns the key to rate limit r by, the client ID of the Principal
+// authenticated by RequestAuthMiddleware if present, otherwise the IP address r was
+// received from. Apply RateLimitMiddleware inside authentication middleware to limit by client.
+func RateLimitKey(r *http.Request) string {
+	return RateLimitKeyWithProxies(nil)(r)
+}
+
+// RateLimitKeyWithProxies returns a function which keys requests like RateLimitKey, except that
+// requests received from trustedProxies are keyed by the rightmost X-Forwarded-For address
+// not in trustedProxies. Addresses left of it could have been set by the client to evade
+// the limit or to exhaust another client's.
+func RateLimitKeyWithProxies(trustedProxies []*net.IPNet) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if clientID, ok := ClientIDFromContext(r.Context()); ok {
+			return "client:" + clientID
+		}
+		return "ip:" + requesterIP(r, trustedProxies)
+	}
+}
+
+// requesterIP returns the host of r's remote address, or if that is a trusted proxy the
+// rightmost address in r's X-Forwarded-For headers which is not a trusted proxy.
+func requesterIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	requester, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		requester = r.RemoteAddr
+	}
+	if !isTrustedProxy(requester, trustedProxies) {
+		return requester
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		requester = address
+		if !isTrustedProxy(address, trustedProxies) {
+			break
+		}
+	}
+	return requester
+}
+
+// isTrustedProxy reports whether address is an IP address in one of trustedProxies.
+func isTrustedProxy(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware provides http middleware which limits how often each client may
+// make requests, keyed by config.Key. Every response includes RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, and requests over the limit are
+// rejected with a JSON 429 and a Retry-After header. Apply it after the authentication
+// middleware so requests are keyed by the authenticated client ID.
+func RateLimitMiddleware(config RateLimitConfig) Middleware {
+	if config.Key == nil {
+		config.Key = RateLimitKeyWithProxies(config.TrustedProxies)
+	}
+	return MiddlewareFunc(func(h http.Handler, w http.ResponseWriter, r *http.Request) {
+		key := config.Key(r)
+		result, err := config.Limiter.Allow(r.Context(), key)
+		if err != nil {
+			if config.FailClosed {
+				WriteError(w, r, config.Logger, apierrors.Unavailable(err, "Rate limiting temporarily unavailable"))
+				return
+			}
+			if config.Logger != nil {
+				config.Logger.Errorw("RateLimitMiddleware: limiter failed, allowing request", r, "key", key, "error", err.Error())
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.ResetAfter))
+		if !result.Allowed {
+			header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			if config.Logger != nil {
+				config.Logger.Warnw("RateLimitMiddleware: rate limit exceeded", r, "key", key)
+			}
+			WriteError(w, r, nil, apierrors.New(apierrors.CodeRateLimited, "Rate limit exceeded"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds formats d as a whole number of seconds, rounding up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/ratelimit"
+)
+
+// mustParseCIDRs parses each CIDR, failing the test on error.
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func TestRateLimitKeyWithProxies(t *testing.T) {
+	key := RateLimitKeyWithProxies(mustParseCIDRs(t, "10.0.0.0/8", "192.168.1.1/32"))
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "ip:203.0.113.7"},
+		{"untrusted peer ignores forwarded", "203.0.113.7:1234", []string{"198.51.100.1"}, "ip:203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:1234", []string{"198.51.100.1"}, "ip:198.51.100.1"},
+		{"spoofed leftmost", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.1"}, "ip:198.51.100.1"},
+		{"proxy chain", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.1, 192.168.1.1"}, "ip:198.51.100.1"},
+		{"multiple headers", "10.0.0.2:1234", []string{"1.2.3.4", "198.51.100.1"}, "ip:198.51.100.1"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.3"}, "ip:10.0.0.3"},
+		{"trusted proxy without forwarded", "10.0.0.2:1234", nil, "ip:10.0.0.2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for _, forwarded := range test.forwarded {
+				request.Header.Add("X-Forwarded-For", forwarded)
+			}
+			if actual := key(request); actual != test.expected {
+				t.Errorf("Expected key %s, got %s", test.expected, actual)
+			}
+		})
+	}
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "10.0.0.2:1234"
+	request.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if actual := RateLimitKey(request); actual != "ip:10.0.0.2" {
+		t.Errorf("Expected RateLimitKey to ignore X-Forwarded-For, got %s", actual)
+	}
+	request = request.WithContext(ContextWithClientID(request.Context(), "client-1"))
+	if actual := RateLimitKey(request); actual != "client:client-1" {
+		t.Errorf("Expected authenticated requests to be keyed by client, got %s", actual)
+	}
+}
+
+func TestRateLimitMiddlewareRejectsOverLimit(t *testing.T) {
+	limiter, err := ratelimit.NewMemoryLimiter(ratelimit.Config{Limit: 1, Period: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		RateLimitMiddleware(RateLimitConfig{Limiter: limiter, Logger: &testLogger{}}))
+	serve := func(forwarded string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Forwarded-For", forwarded)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := serve("1.1.1.1"); recorder.Code != http.StatusOK || recorder.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("Expected first request to be allowed, got %d and %v", recorder.Code, recorder.Header())
+	}
+	recorder := serve("2.2.2.2")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a rotated X-Forwarded-For not to evade the limit, got %d and %v", recorder.Code, recorder.Header())
+	}
+}
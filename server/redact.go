@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RedactedValue replaces the value of redacted JSON fields and headers in logs
+	RedactedValue = "[REDACTED]"
+	// DefaultMaxLoggedBodyBytes is the number of request body bytes logged by JSONLoggingMiddleware
+	DefaultMaxLoggedBodyBytes = 4096
+)
+
+var (
+	// DefaultRedactedFields are the JSON fields redacted from logged request bodies by default
+	DefaultRedactedFields = []string{"password", "secret", "token", "private_key"}
+	// DefaultRedactedHeaders are the header name rules redacted from logged requests by
+	// default, e.g. "auth" matches Authorization and X-Tozny-Authn and "token" matches
+	// X-Auth-Token and X-Amz-Security-Token
+	DefaultRedactedHeaders = []string{
+		"auth",
+		"cookie",
+		"token",
+		"secret",
+		"key",
+		"password",
+		"credential",
+		"signature",
+		"session",
+		"csrf",
+		"xsrf",
+		ToznyOpenAuthenticationTokenHeader,
+	}
+)
+
+// redactor redacts sensitive JSON fields and headers from requests before they are logged.
+type redactor struct {
+	fields  []string        // Lowercase field names matched anywhere in a document
+	paths   map[string]bool // Lowercase dotted paths matched from the root of a document
+	headers []string        // Lowercase header name rules matched anywhere in a header name
+	keys    *regexp.Regexp  // Matches the keys of redacted fields in documents which could not be parsed
+}
+
+// newRedactor returns a redactor for the provided field and header rules. Field rules
+// without a dot match (case insensitively) any object key containing them at any depth,
+// e.g. "token" matches "access_token". Rules with dots match an exact path from the root
+// of the document, e.g. "user.credentials.pin", where arrays are transparent. Header rules
+// match (case insensitively) any header name containing them, e.g. "token" matches X-Auth-Token.
+func newRedactor(fields []string, headers []string) *redactor {
+	redactor := &redactor{
+		paths: map[string]bool{},
+	}
+	var keys []string
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		if strings.Contains(field, ".") {
+			redactor.paths[field] = true
+			field = field[strings.LastIndex(field, ".")+1:]
+		} else {
+			redactor.fields = append(redactor.fields, field)
+		}
+		keys = append(keys, regexp.QuoteMeta(field))
+	}
+	for _, header := range headers {
+		redactor.headers = append(redactor.headers, strings.ToLower(header))
+	}
+	if len(keys) > 0 {
+		// Matches "<key containing a rule>": up to the start of its value
+		redactor.keys = regexp.MustCompile(`(?i)"[^"]*(?:` + strings.Join(keys, "|") + `)[^"]*"\s*:\s*`)
+	}
+	return redactor
+}
+
+// redactKey reports whether the value at path, whose last element is key, should be redacted.
+func (rd *redactor) redactKey(path string, key string) bool {
+	if rd.paths[path] {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, field := range rd.fields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue returns value with every redacted field replaced, where path is the lowercase
+// dotted path of value in the document.
+func (rd *redactor) redactValue(value interface{}, path string) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, member := range typed {
+			memberPath := strings.ToLower(key)
+			if path != "" {
+				memberPath = path + "." + memberPath
+			}
+			if rd.redactKey(memberPath, key) {
+				typed[key] = RedactedValue
+				continue
+			}
+			typed[key] = rd.redactValue(member, memberPath)
+		}
+	case []interface{}:
+		for index, element := range typed {
+			typed[index] = rd.redactValue(element, path)
+		}
+	}
+	return value
+}
+
+// redactBody returns the JSON body with sensitive fields redacted. Complete documents are
+// redacted structurally, while truncated documents, which can not be parsed, are redacted by
+// key. The second return value is false if body is complete but not valid JSON.
+func (rd *redactor) redactBody(body []byte, truncated bool) (string, bool) {
+	if truncated {
+		return rd.redactTruncated(string(body)), true
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil || decoder.More() {
+		return "", false
+	}
+	redacted, err := json.Marshal(rd.redactValue(document, ""))
+	if err != nil {
+		return "", false
+	}
+	return string(redacted), true
+}
+
+// redactTruncated returns the truncated JSON document with the value following every
+// redacted key replaced, including the whole of (possibly unterminated) objects and arrays.
+func (rd *redactor) redactTruncated(body string) string {
+	if rd.keys == nil {
+		return body
+	}
+	var redacted strings.Builder
+	for {
+		match := rd.keys.FindStringIndex(body)
+		if match == nil {
+			redacted.WriteString(body)
+			return redacted.String()
+		}
+		redacted.WriteString(body[:match[1]])
+		redacted.WriteString(`"` + RedactedValue + `"`)
+		body = body[match[1]:]
+		body = body[jsonValueLength(body):]
+	}
+}
+
+// jsonValueLength returns the length of the JSON value at the start of document, or the
+// length of document if the value is not terminated before the document ends.
+func jsonValueLength(document string) int {
+	if document == "" {
+		return 0
+	}
+	switch document[0] {
+	case '"', '{', '[':
+	default:
+		// Numbers, booleans and null end at the next delimiter
+		if end := strings.IndexAny(document, ",}] \t\r\n"); end >= 0 {
+			return end
+		}
+		return len(document)
+	}
+	depth, inString, escaped := 0, false, false
+	for i := 0; i < len(document); i++ {
+		c := document[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+			if !inString && depth == 0 {
+				return i + 1
+			}
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(document)
+}
+
+// redactHeaders returns a copy of headers with the values of sensitive headers redacted.
+func (rd *redactor) redactHeaders(headers http.Header) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for name, values := range headers {
+		if rd.redactHeader(name) {
+			redacted[name] = RedactedValue
+			continue
+		}
+		redacted[name] = strings.Join(values, ", ")
+	}
+	return redacted
+}
+
+// redactHeader reports whether the value of the header name should be redacted.
+func (rd *redactor) redactHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, header := range rd.headers {
+		if strings.Contains(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// isJSONContentType reports whether contentType describes a JSON body. Requests without a
+// content type are treated as JSON so that their bodies are logged if they parse.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// omittedBody describes a request body which was not logged.
+func omittedBody(reason string) string {
+	return fmt.Sprintf("[omitted: %s]", reason)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactBodyRedactsCompleteDocuments(t *testing.T) {
+	redactor := newRedactor(append(DefaultRedactedFields, "user.pin"), nil)
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"top level", `{"name":"n","password":"hunter2"}`, `{"name":"n","password":"[REDACTED]"}`},
+		{"substring and case", `{"Access_Token":"abc"}`, `{"Access_Token":"[REDACTED]"}`},
+		{"nested object", `{"secret":{"value":"abc"}}`, `{"secret":"[REDACTED]"}`},
+		{"within arrays", `{"keys":[{"private_key":"abc","id":1}]}`, `{"keys":[{"id":1,"private_key":"[REDACTED]"}]}`},
+		{"dotted path", `{"user":{"pin":1234},"pin":5}`, `{"pin":5,"user":{"pin":"[REDACTED]"}}`},
+		{"numbers preserved", `{"amount":12345678901234567890}`, `{"amount":12345678901234567890}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted, ok := redactor.redactBody([]byte(test.body), false)
+			if !ok || redacted != test.expected {
+				t.Errorf("Expected %s, got %s (ok %t)", test.expected, redacted, ok)
+			}
+		})
+	}
+	if _, ok := redactor.redactBody([]byte(`{"password":`), false); ok {
+		t.Errorf("Expected invalid complete JSON to be reported")
+	}
+}
+
+func TestRedactBodyRedactsTruncatedDocuments(t *testing.T) {
+	redactor := newRedactor(DefaultRedactedFields, nil)
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"string", `{"password":"hunter2","name":"n`, `{"password":"[REDACTED]","name":"n`},
+		{"escaped quotes", `{"token":"a\"b,c}","id":1,"na`, `{"token":"[REDACTED]","id":1,"na`},
+		{"unterminated string", `{"id":1,"secret":"abc`, `{"id":1,"secret":"[REDACTED]"`},
+		{"scalar", `{"token":12345,"id":`, `{"token":"[REDACTED]","id":`},
+		{"object", `{"secret":{"a":"x","b":[1,2]},"id":1,`, `{"secret":"[REDACTED]","id":1,`},
+		{"array", `{"private_key":["x","y}"],"id":1,`, `{"private_key":"[REDACTED]","id":1,`},
+		{"unterminated object", `{"id":1,"secret":{"a":"x","b":{"c"`, `{"id":1,"secret":"[REDACTED]"`},
+		{"nested key", `{"user":{"password":"x"},"items":[`, `{"user":{"password":"[REDACTED]"},"items":[`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted, ok := redactor.redactBody([]byte(test.body), true)
+			if !ok || redacted != test.expected {
+				t.Errorf("Expected %s, got %s (ok %t)", test.expected, redacted, ok)
+			}
+		})
+	}
+}
+
+func TestRedactHeadersRedactsDefaultHeaders(t *testing.T) {
+	redactor := newRedactor(nil, DefaultRedactedHeaders)
+	headers := http.Header{}
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", ToznyAuthNHeader, ToznyOpenAuthenticationTokenHeader,
+		"X-Auth-Token", "X-Amz-Security-Token", "X-Csrf-Token", "X-Client-Secret", "X-Session-Id"} {
+		headers.Set(name, "credential")
+	}
+	// Non canonical names are matched too
+	headers["x-refresh-token"] = []string{"credential"}
+	logged := []string{"Content-Type", "Accept", "User-Agent", "X-Request-Id"}
+	for _, name := range logged {
+		headers.Set(name, "value")
+	}
+	redacted := redactor.redactHeaders(headers)
+	for name, value := range redacted {
+		if headers[name][0] == "credential" && value != RedactedValue {
+			t.Errorf("Expected %s to be redacted, got %s", name, value)
+		}
+		if headers[name][0] == "value" && value != "value" {
+			t.Errorf("Expected %s to be logged, got %s", name, value)
+		}
+	}
+}
+
+func TestJSONLoggingMiddlewareRedactsAndPreservesBody(t *testing.T) {
+	logger := &testLogger{}
+	body := `{"name":"n","secret":{"value":"` + strings.Repeat("s", 64) + `"}}`
+	var handlerBody string
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read, _ := io.ReadAll(r.Body)
+		handlerBody = string(read)
+	}), JSONLoggingMiddlewareWithConfig(JSONLoggingConfig{Logger: logger, MaxBodyBytes: 48}))
+	request := httptest.NewRequest(http.MethodPost, "/v1/things", strings.NewReader(body))
+	request.Header.Set(ToznyAuthNHeader, "credential")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	logged := logger.String()
+	if strings.Contains(logged, "credential") || strings.Contains(logged, "sss") {
+		t.Errorf("Expected secrets to be redacted, got\n%s", logged)
+	}
+	if !strings.Contains(logged, "request_body_truncated:true") {
+		t.Errorf("Expected the body to be logged as truncated, got\n%s", logged)
+	}
+	if handlerBody != body {
+		t.Errorf("Expected the handler to read the whole body, got %s", handlerBody)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter is an http.ResponseWriter which records the status code and
+// number of body bytes written, for use by middleware which reports on responses.
+// It implements http.Flusher and http.Hijacker only when the writer it wraps does.
+type ResponseWriter interface {
+	http.ResponseWriter
+	// Status returns the status code written, or 0 if nothing has been written yet
+	Status() int
+	// BytesWritten returns the number of body bytes written
+	BytesWritten() int64
+	// Unwrap returns the wrapped http.ResponseWriter, for use by http.ResponseController
+	Unwrap() http.ResponseWriter
+}
+
+// responseWriter implements ResponseWriter by wrapping an http.ResponseWriter.
+type responseWriter struct {
+	http.ResponseWriter
+	status       int
+	bytesWritten int64
+}
+
+// WrapResponseWriter returns w as a ResponseWriter, wrapping it if it does not already record
+// responses. The result implements http.Flusher and http.Hijacker only if w does, so
+// handlers checking for those interfaces see the same capabilities as without the wrapper.
+func WrapResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if wrapped, ok := w.(ResponseWriter); ok {
+		return wrapped
+	}
+	rw := &responseWriter{ResponseWriter: w}
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackResponseWriter{rw}
+	case flusher:
+		return flushResponseWriter{rw}
+	case hijacker:
+		return hijackResponseWriter{rw}
+	}
+	return rw
+}
+
+// flushResponseWriter is a responseWriter wrapping an http.Flusher.
+type flushResponseWriter struct{ *responseWriter }
+
+func (rw flushResponseWriter) Flush() { rw.flush() }
+
+// hijackResponseWriter is a responseWriter wrapping an http.Hijacker.
+type hijackResponseWriter struct{ *responseWriter }
+
+func (rw hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return rw.hijack() }
+
+// flushHijackResponseWriter is a responseWriter wrapping an http.Flusher and http.Hijacker.
+type flushHijackResponseWriter struct{ *responseWriter }
+
+func (rw flushHijackResponseWriter) Flush() { rw.flush() }
+
+func (rw flushHijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return rw.hijack()
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	// Informational responses other than 101 Switching Protocols precede the final status
+	informational := statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+	if rw.status == 0 && !informational {
+		rw.status = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytesWritten += int64(n)
+	return n, err
+}
+
+// flush flushes the wrapped http.Flusher, which starts the response if it hasn't been.
+func (rw *responseWriter) flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.ResponseWriter.(http.Flusher).Flush()
+}
+
+// hijack hijacks the wrapped http.Hijacker's connection.
+func (rw *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := rw.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, buffer, err
+}
+
+func (rw *responseWriter) Status() int {
+	return rw.status
+}
+
+func (rw *responseWriter) BytesWritten() int64 {
+	return rw.bytesWritten
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
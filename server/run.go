@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	apierrors "github.com/tozny/utils-go/errors"
+	"github.com/tozny/utils-go/health"
+	"github.com/tozny/utils-go/lifecycle"
+	"github.com/tozny/utils-go/logging"
+)
+
+const (
+	// DefaultShutdownTimeout is how long Run waits for in-flight requests to finish when shutting down
+	DefaultShutdownTimeout = 30 * time.Second
+	// DefaultReadHeaderTimeout is how long Run's server waits for a request's headers
+	DefaultReadHeaderTimeout = 10 * time.Second
+	// InitializationCheckName is the name of the check Run registers in RunConfig.Health
+	InitializationCheckName = "initialization"
+)
+
+// Readiness states of a server started by Run.
+const (
+	stateInitializing int32 = iota
+	stateReady
+	stateShuttingDown
+)
+
+var (
+	// ErrorNotReady is the error reported while a server started by Run is initializing or shutting down
+	ErrorNotReady = errors.New("Service is not ready")
+)
+
+// RunConfig wraps configuration for Run.
+type RunConfig struct {
+	Address           string             // TCP address to listen on, e.g. ":8000"
+	Listener          net.Listener       // Listener to serve on instead of listening on Address
+	Handler           http.Handler       // Handler for all requests
+	CheckPaths        []string           // Exact request paths (e.g. "/v1/things/healthcheck") served before initialization finishes
+	Manager           *lifecycle.Manager // Manager whose initialization gates readiness and whose closers run after requests drain
+	Health            *health.Registry   // Registry to register a readiness check in, optional
+	Logger            logging.Logger     // Logger for startup and shutdown progress
+	ShutdownTimeout   time.Duration      // How long to wait for in-flight requests to finish, zero uses DefaultShutdownTimeout
+	ReadHeaderTimeout time.Duration      // How long to wait for request headers, zero uses DefaultReadHeaderTimeout
+	Signals           []os.Signal        // Signals which start a graceful shutdown, nil uses SIGINT and SIGTERM
+}
+
+// Run serves config.Handler until ctx is done or a shutdown signal is received,
+// then shuts down gracefully. The listener starts immediately, but until the
+// initialization of config.Manager (see lifecycle.Manager.WG) finishes every request
+// except those to config.CheckPaths is rejected with a 503, and the readiness check
+// registered in config.Health fails. On shutdown the readiness check fails again and the server stops
+// accepting connections, waits up to config.ShutdownTimeout for in-flight requests to
+// finish, closes any remaining connections, and only then closes config.Manager so
+// that dependencies such as databases outlive the requests using them. Run returns nil
+// after a graceful shutdown, otherwise the error which stopped the server.
+func Run(ctx context.Context, config RunConfig) error {
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if config.ReadHeaderTimeout <= 0 {
+		config.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if config.Signals == nil {
+		config.Signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	listener := config.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", config.Address)
+		if err != nil {
+			return err
+		}
+	}
+	var state atomic.Int32
+	if config.Health != nil {
+		config.Health.Register(InitializationCheckName, func(context.Context) error {
+			if state.Load() != stateReady {
+				return ErrorNotReady
+			}
+			return nil
+		})
+	}
+	httpServer := &http.Server{
+		Handler:           readinessGate(config.Handler, &state, config.CheckPaths),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+	config.Logger.Printf("Server listening on %s", listener.Addr())
+	go func() {
+		if config.Manager != nil {
+			config.Manager.WG.Wait()
+		}
+		// Initialization finishing during shutdown must not mark the server ready again
+		if state.CompareAndSwap(stateInitializing, stateReady) {
+			config.Logger.Println("Server initialized and ready")
+		}
+	}()
+
+	signalCtx, stop := signal.NotifyContext(ctx, config.Signals...)
+	defer stop()
+	var err error
+	select {
+	case err = <-serveErr:
+		config.Logger.Errorf("Server stopped unexpectedly: %s", err)
+	case <-signalCtx.Done():
+		config.Logger.Println("Server shutting down, draining in-flight requests")
+		state.Store(stateShuttingDown)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err = httpServer.Shutdown(shutdownCtx); err != nil {
+			config.Logger.Errorf("Server did not drain within %s, closing remaining connections: %s", config.ShutdownTimeout, err)
+			httpServer.Close()
+		}
+	}
+	if config.Manager != nil {
+		config.Manager.Close()
+	}
+	config.Logger.Println("Server shut down")
+	return err
+}
+
+// readinessGate rejects requests to paths other than checkPaths with a 503 unless state is ready.
+func readinessGate(h http.Handler, state *atomic.Int32, checkPaths []string) http.Handler {
+	isCheckPath := make(map[string]bool, len(checkPaths))
+	for _, path := range checkPaths {
+		isCheckPath[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state.Load() != stateReady && !isCheckPath[r.URL.Path] {
+			WriteError(w, r, nil, apierrors.Unavailable(ErrorNotReady, ErrorNotReady.Error()))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
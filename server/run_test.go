@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tozny/utils-go/health"
+	"github.com/tozny/utils-go/lifecycle"
+)
+
+// initializerFunc adapts a function to lifecycle.Initializer.
+type initializerFunc func()
+
+func (f initializerFunc) Initialize() { f() }
+
+// closerFunc adapts a function to lifecycle.Closer.
+type closerFunc func()
+
+func (f closerFunc) Close() { f() }
+
+// startRun starts Run on a local listener, returning the server's base URL and a channel receiving Run's result.
+func startRun(t *testing.T, ctx context.Context, config RunConfig) (string, chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Listener = listener
+	config.Logger = &testLogger{}
+	result := make(chan error, 1)
+	go func() {
+		result <- Run(ctx, config)
+	}()
+	return "http://" + listener.Addr().String(), result
+}
+
+// getStatus returns the status code of a GET request to url, or 0 if the request failed.
+func getStatus(url string) int {
+	response, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
+
+// eventually polls condition until it is true or a second has passed.
+func eventually(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestRunGatesRequestsUntilInitialized(t *testing.T) {
+	manager := lifecycle.NewManager(&testLogger{})
+	initialized := make(chan struct{})
+	manager.ManageInitialization(initializerFunc(func() { <-initialized }))
+	registry := health.NewRegistry(health.Config{CacheTTL: -1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, result := startRun(t, ctx, RunConfig{
+		Handler:    http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		CheckPaths: []string{"/v1/things/healthcheck"},
+		Manager:    &manager,
+		Health:     registry,
+	})
+	if !eventually(func() bool { return getStatus(url+"/v1/things/healthcheck") == http.StatusOK }) {
+		t.Fatalf("Expected the configured check path to be served before initialization")
+	}
+	for _, path := range []string{"/v1/things", "/v1/other/healthcheck"} {
+		if status := getStatus(url + path); status != http.StatusServiceUnavailable {
+			t.Errorf("Expected %s to be rejected before initialization, got %d", path, status)
+		}
+	}
+	if registry.Check(ctx).Healthy() {
+		t.Errorf("Expected the readiness check to fail before initialization")
+	}
+	close(initialized)
+	if !eventually(func() bool { return getStatus(url+"/v1/things") == http.StatusOK }) {
+		t.Errorf("Expected requests to be served once initialized")
+	}
+	if !registry.Check(ctx).Healthy() {
+		t.Errorf("Expected the readiness check to pass once initialized")
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Expected graceful shutdown, got %s", err)
+	}
+}
+
+func TestRunDrainsRequestsBeforeClosingManager(t *testing.T) {
+	manager := lifecycle.NewManager(&testLogger{})
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	manager.ManageClose(closerFunc(func() { record("manager closed") }))
+	started, release := make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, result := startRun(t, ctx, RunConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			record("request finished")
+		}),
+		Manager: &manager,
+	})
+	requestStatus := make(chan int, 1)
+	go func() {
+		// Retry until the server is ready, the handler only runs once
+		for status := 0; ; {
+			if status = getStatus(url); status != http.StatusServiceUnavailable && status != 0 {
+				requestStatus <- status
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-result:
+		t.Fatalf("Expected Run to wait for the in-flight request, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("Expected graceful shutdown, got %s", err)
+	}
+	if status := <-requestStatus; status != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got %d", status)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(events) != 2 || events[0] != "request finished" || events[1] != "manager closed" {
+		t.Errorf("Expected requests to drain before the manager closed, got %v", events)
+	}
+}
+
+func TestRunStaysNotReadyWhenInitializedDuringShutdown(t *testing.T) {
+	manager := lifecycle.NewManager(&testLogger{})
+	initialized := make(chan struct{})
+	manager.ManageInitialization(initializerFunc(func() { <-initialized }))
+	registry := health.NewRegistry(health.Config{CacheTTL: -1})
+	ctx, cancel := context.WithCancel(context.Background())
+	_, result := startRun(t, ctx, RunConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Manager: &manager,
+		Health:  registry,
+	})
+	cancel()
+	<-result
+	close(initialized)
+	time.Sleep(20 * time.Millisecond)
+	if registry.Check(context.Background()).Healthy() {
+		t.Errorf("Expected initialization finishing after shutdown not to mark the server ready")
+	}
+}
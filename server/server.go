@@ -18,28 +18,38 @@ var (
 	ErrorInvalidAuthToken = errors.New("InvalidAuthToken")
 	// ErrorInvalidAuthentication is a static error returned when request authentication fails
 	ErrorInvalidAuthentication = errors.New("Invalid authentication attempt")
+	// ErrorAuthenticationUnavailable is a static error returned when requests can not be authenticated
+	// because the authentication service is unavailable
+	ErrorAuthenticationUnavailable = errors.New("Authentication temporarily unavailable")
 )
 
+// ExtractAuthorization splits the provided request's Authorization header into its
+// type (e.g. Bearer) and credentials, returning ErrorInvalidAuthorizationHeader if the
+// header is missing or malformed.
+func ExtractAuthorization(r *http.Request) (string, string, error) {
+	authParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(authParts) != 2 || authParts[0] == "" || authParts[1] == "" {
+		return "", "", ErrorInvalidAuthorizationHeader
+	}
+	return authParts[0], authParts[1], nil
+}
+
 // ExtractBearerToken attempts to extract an Oauth bearer token
 // from the provided request, returning extracted token and error (if any)
 func ExtractBearerToken(r *http.Request) (string, error) {
-	var authToken string
-	authHeader := r.Header.Get("Authorization")
-	authParts := strings.Split(authHeader, " ")
-	if len(authParts) != 2 {
-		return authToken, ErrorInvalidAuthorizationHeader
+	authType, authToken, err := ExtractAuthorization(r)
+	if err != nil {
+		return "", err
 	}
-	authType := authParts[0]
 	var invalidAuthType = true
 	for _, supportedType := range SupportedAuthTypes {
-		if authType == supportedType {
+		if strings.EqualFold(authType, supportedType) {
 			invalidAuthType = false
 			break
 		}
 	}
 	if invalidAuthType {
-		return authToken, ErrorUnsupportedAuthorizationType
+		return "", ErrorUnsupportedAuthorizationType
 	}
-	authToken = authParts[1]
 	return authToken, nil
 }
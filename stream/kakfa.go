@@ -9,6 +9,8 @@ import (
 	cloudevent "github.com/cloudevents/sdk-go/v2/event"
 	"github.com/google/uuid"
 	"github.com/tozny/utils-go/logging"
+	"github.com/tozny/utils-go/requestid"
+	"github.com/tozny/utils-go/retry"
 	"log"
 )
 
@@ -16,16 +18,18 @@ const (
 	AnyPartitionPublishFlag      = -1  // Value to use to signal Kafka client to publish messages to any partition
 	SubscribeBufferSize          = 256 // Max number of messages to buffer when subscribing to a stream
 	defaultReceiverGroupIdPrefix = "tozny-ce-"
+	requestIDExtension           = "requestid" // CloudEvents extension attribute names are limited to lowercase letters and digits
 )
 
 // KafkaStreamConfig wraps configuration for a Kafka stream
 type KafkaStreamConfig struct {
-	BrokerEndpoints     []string       // List of broker endpoints used to publish and or subscribe to this Kafka stream
-	Topic               string         // Which Kafka service endpoint to use for stream interactions
+	BrokerEndpoints     []string       `env:"KAFKA_BROKER_ENDPOINTS,required"` // List of broker endpoints used to publish and or subscribe to this Kafka stream
+	Topic               string         `env:"KAFKA_TOPIC,required"`            // Which Kafka service endpoint to use for stream interactions
 	Logger              logging.Logger // Logger to use for stream trace logs
-	Partition           int32          // Kafka server defined shard of the stream to consume and publish messages from
-	Offset              int64          // Offset to use for determining where in the stream to start consuming and subscribing to messages
-	SubscribeBufferSize int            // Max Number of messages to buffer when subscribing to a stream
+	Partition           int32          `env:"KAFKA_PARTITION" default:"0"`               // Kafka server defined shard of the stream to consume and publish messages from
+	Offset              int64          `env:"KAFKA_OFFSET" default:"-1"`                 // Offset to use for determining where in the stream to start consuming and subscribing to messages
+	SubscribeBufferSize int            `env:"KAFKA_SUBSCRIBE_BUFFER_SIZE" default:"256"` // Max Number of messages to buffer when subscribing to a stream
+	Retrier             *retry.Retrier // Optional retrier for publishing messages, nil disables retries
 }
 
 // KafkaStream wraps a concrete (Apacha Kafka) distributed stream
@@ -34,6 +38,7 @@ type KafkaStream struct {
 	BrokerEndpoints []string            // List of broker endpoints used to publish and or subscribe to this Kafka stream
 	logger          logging.Logger      // Logger to use for stream trace logs
 	config          KafkaStreamConfig   // Private and static configuration for this Kafka stream
+	client          sarama.Client       // Private Kafka client shared by the producer, consumer and pings
 	producer        sarama.SyncProducer // Private Kafka client for synchronous publishing of messages to a Kafka stream
 	consumer        sarama.Consumer     // Private Kafka client for consuming messages from a Kafka stream
 }
@@ -49,15 +54,23 @@ func convertEventToMessage(event Event, partition int32) *sarama.ProducerMessage
 	if event.Message != "" {
 		message.Value = sarama.StringEncoder(event.Message)
 	}
+	if event.RequestID != "" {
+		message.Headers = []sarama.RecordHeader{{Key: []byte(requestid.Key), Value: []byte(event.RequestID)}}
+	}
 	return message
 }
 
 // Publish publishes N events to the underlying Kafka stream,
 // returning the published events and error (if any).
 func (ks *KafkaStream) Publish(events []Event) ([]Event, error) {
+	return ks.PublishContext(context.Background(), events)
+}
+
+// PublishContext is Publish, cancelling retries of a failed publish when ctx is done.
+func (ks *KafkaStream) PublishContext(ctx context.Context, events []Event) ([]Event, error) {
 	for index, event := range events {
 		message := convertEventToMessage(event, ks.config.Partition)
-		partition, offset, err := ks.producer.SendMessage(message)
+		partition, offset, err := ks.sendMessage(ctx, message)
 		if err != nil {
 			return events, err
 		}
@@ -68,8 +81,41 @@ func (ks *KafkaStream) Publish(events []Event) ([]Event, error) {
 	return events, nil
 }
 
+// sendMessage synchronously publishes message, retrying with the stream's
+// retrier if one was configured until ctx is done.
+func (ks *KafkaStream) sendMessage(ctx context.Context, message *sarama.ProducerMessage) (int32, int64, error) {
+	if ks.config.Retrier == nil {
+		return ks.producer.SendMessage(message)
+	}
+	var partition int32
+	var offset int64
+	err := ks.config.Retrier.Do(ctx, func(context.Context) error {
+		var err error
+		partition, offset, err = ks.producer.SendMessage(message)
+		return err
+	})
+	return partition, offset, err
+}
+
+// PingContext checks the stream's brokers are reachable by fetching metadata for its
+// topic with the stream's client, returning error (if any). It implements health.Pinger
+// for service checks.
+func (ks *KafkaStream) PingContext(ctx context.Context) error {
+	// The refresh is bounded by the client's metadata timeouts, so it finishes even if ctx is done first
+	done := make(chan error, 1)
+	go func() {
+		done <- ks.client.RefreshMetadata(ks.config.Topic)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func convertMessageToEvent(message *sarama.ConsumerMessage, topic string) Event {
-	return Event{
+	event := Event{
 		Topic:     topic,
 		Tag:       string(message.Key),
 		Message:   string(message.Value),
@@ -77,6 +123,12 @@ func convertMessageToEvent(message *sarama.ConsumerMessage, topic string) Event
 		Partition: fmt.Sprint(message.Partition),
 		SortKey:   fmt.Sprint(message.Offset),
 	}
+	for _, header := range message.Headers {
+		if header != nil && string(header.Key) == requestid.Key {
+			event.RequestID = string(header.Value)
+		}
+	}
+	return event
 }
 
 // Subscribe opens a connection to a Kafka stream, returning a channel
@@ -133,13 +185,19 @@ func NewKafkaStream(config KafkaStreamConfig) (Stream, error) {
 
 	kafkaConfig := generateKafkaConfig()
 
-	kafkaProducer, err := sarama.NewSyncProducer(config.BrokerEndpoints, kafkaConfig)
+	kafkaClient, err := sarama.NewClient(config.BrokerEndpoints, kafkaConfig)
+	if err != nil {
+		return kafkaStream, err
+	}
+	kafkaStream.client = kafkaClient
+
+	kafkaProducer, err := sarama.NewSyncProducerFromClient(kafkaClient)
 	if err != nil {
 		return kafkaStream, err
 	}
 	kafkaStream.producer = kafkaProducer
 
-	kafkaConsumer, err := sarama.NewConsumer(config.BrokerEndpoints, kafkaConfig)
+	kafkaConsumer, err := sarama.NewConsumerFromClient(kafkaClient)
 	if err != nil {
 		return kafkaStream, err
 	}
@@ -233,16 +291,21 @@ func createCloudEventFromEvent(event CloudEvent) cloudevent.Event {
 	e.SetSource(event.Source)
 	e.SetTime(event.Timestamp)
 	_ = e.SetData(event.ContentType, event.Data)
+	if event.RequestID != "" {
+		e.SetExtension(requestIDExtension, event.RequestID)
+	}
 	return e
 }
 
 func createEventFromCloudEvent(event cloudevents.Event) CloudEvent {
+	requestID, _ := event.Extensions()[requestIDExtension].(string)
 	return CloudEvent{
 		Type:        event.Type(),
 		Source:      event.Source(),
 		ContentType: event.DataContentType(),
 		Data:        event.Data(),
 		Timestamp:   event.Time(),
+		RequestID:   requestID,
 	}
 }
 
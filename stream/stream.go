@@ -17,6 +17,7 @@ type Event struct {
 	Timestamp time.Time // The timestamp for when the event was first published to the stream
 	Partition string    // The server side resource this event is stored or has been subscribed from
 	SortKey   string    // Server defined unique and monotonic key for ordering of published events
+	RequestID string    // ID of the request which caused this event, carried in the event headers
 }
 
 // CloudEvent wraps information and metadata about a cloud event published to a stream
@@ -30,6 +31,7 @@ type CloudEvent struct {
 	Timestamp   time.Time   // The timestamp for when the event was first published to the stream
 	Partition   string      // The server side resource this event is stored or has been subscribed from
 	SortKey     string      // Server defined unique and monotonic key for ordering of published events
+	RequestID   string      // ID of the request which caused this event, carried as a CloudEvents extension
 }
 
 // ReadOnlyStream wraps functionality for
@@ -4,6 +4,8 @@ import (
 	"encoding/base64"
 	"strings"
 	"time"
+
+	"github.com/tozny/utils-go/clock"
 )
 
 // IsValidKey ensures a base64URL encoded key of a specific type is base64URL
@@ -43,9 +45,34 @@ func IsValidDotted(subject string) (int, bool) {
 	return len(parts), true
 }
 
+// Option configures optional behavior of a validation function.
+type Option func(*options)
+
+// options holds the configurable behavior of validation functions.
+type options struct {
+	clock clock.Clock
+}
+
+// WithClock sets the clock used to read the current time during validation.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// applyOptions returns the options described by opts, defaulting to the system clock.
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.clock = clock.OrNew(o.clock)
+	return o
+}
+
 // IsTimeWithinWindow determines if the time given happened within the defined windowSeconds
-func IsTimeWithinWindow(timeToValidate time.Time, windowSeconds int) bool {
-	now := time.Now()
+func IsTimeWithinWindow(timeToValidate time.Time, windowSeconds int, opts ...Option) bool {
+	now := applyOptions(opts).clock.Now()
 	previousValidTime := now.Add(time.Duration(-windowSeconds) * time.Second)
 	if previousValidTime.After(timeToValidate) {
 		return false
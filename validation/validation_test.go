@@ -3,6 +3,8 @@ package validation
 import (
 	"testing"
 	"time"
+
+	"github.com/tozny/utils-go/clock"
 )
 
 func TestIsTimeWithinWindow(t *testing.T) {
@@ -19,3 +21,16 @@ func TestIsTimeWithinWindow(t *testing.T) {
 		t.Errorf("Time was within window when it wasn't expect to be")
 	}
 }
+
+func TestIsTimeWithinWindowWithClock(t *testing.T) {
+	issued := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFake(issued)
+	fakeClock.Advance(10 * time.Second)
+	if !IsTimeWithinWindow(issued, 10, WithClock(fakeClock)) {
+		t.Errorf("Time was not within window at the edge of the window")
+	}
+	fakeClock.Advance(time.Second)
+	if IsTimeWithinWindow(issued, 10, WithClock(fakeClock)) {
+		t.Errorf("Time was within window after the window passed")
+	}
+}